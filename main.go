@@ -19,12 +19,17 @@ var (
 func main() {
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
+	metricsSinkURL := flags.String("metrics-sink-url", "", "URL of the statsd or statsite sink of the plugin metrics")
 	_ = flags.Parse(os.Args[1:])
 
 	tlsConfig := apiClientMeta.GetTLSConfig()
 	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
 
 	log.Printf("vault-artifactory-secrets-plugin %s, commit %s, built at %s\n", version, commit, date)
+	artifactorysecrets.SetBuildInfo(version, commit, date)
+	if err := artifactorysecrets.ConfigureMetrics(*metricsSinkURL); err != nil {
+		log.Fatal(err)
+	}
 	if err := plugin.Serve(&plugin.ServeOpts{
 		BackendFactoryFunc: artifactorysecrets.Factory,
 		TLSProviderFunc:    tlsProviderFunc,
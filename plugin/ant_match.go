@@ -0,0 +1,99 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"regexp"
+	"strings"
+)
+
+// identity templates are only known at token time, they match like a '*'
+var identityTemplateRegex = regexp.MustCompile(`\{\{[^}]*\}\}`)
+
+// antMatch reports whether the repository path matches the Ant-style pattern, as used by
+// permission target include and exclude patterns: '?' matches one character, '*' any characters
+// within a folder and '**' any number of folders. Like Artifactory, a pattern ending with '/'
+// matches everything below that folder.
+func antMatch(pattern, path string) bool {
+	pattern = identityTemplateRegex.ReplaceAllString(pattern, "*")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchSegments(splitPath(pattern), splitPath(path))
+}
+
+func splitPath(path string) []string {
+	segments := []string{}
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// matchSegments matches folders and file names against the pattern segments. Like matchSegment,
+// it only returns to the last '**' on a mismatch, which keeps it linear in practice and
+// O(len(pattern)*len(path)) segment matches at worst.
+func matchSegments(pattern, path []string) bool {
+	p, s := 0, 0
+	star, mark := -1, 0
+	for s < len(path) {
+		switch {
+		case p < len(pattern) && pattern[p] == "**":
+			star, mark = p, s
+			p++
+		case p < len(pattern) && matchSegment(pattern[p], path[s]):
+			p++
+			s++
+		case star >= 0:
+			// let the last '**' take one more folder
+			mark++
+			p, s = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == "**" {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchSegment matches a single folder or file name against '*' and '?' wildcards
+func matchSegment(pattern, name string) bool {
+	p, n := 0, 0
+	star, mark := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, n
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case star >= 0:
+			// let the last '*' take one more character
+			mark++
+			p, n = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,90 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAntMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{pattern: "**", path: "", match: true},
+		{pattern: "**", path: "com/acme/lib/1.0/lib-1.0.jar", match: true},
+		{pattern: "com/acme/**", path: "com/acme", match: true},
+		{pattern: "com/acme/**", path: "com/acme/lib/1.0/lib-1.0.jar", match: true},
+		{pattern: "/com/acme/**", path: "com/acme/lib", match: true},
+		{pattern: "com/acme/**", path: "com/acmecorp/lib", match: false},
+		{pattern: "com/acme/", path: "com/acme/lib/1.0", match: true},
+		{pattern: "com/*/lib/**", path: "com/acme/lib/1.0", match: true},
+		{pattern: "com/*/lib/**", path: "com/acme/corp/lib/1.0", match: false},
+		{pattern: "com/**/lib/**", path: "com/acme/corp/lib/1.0", match: true},
+		{pattern: "**/*.jar", path: "com/acme/lib-1.0.jar", match: true},
+		{pattern: "**/*.jar", path: "com/acme/lib-1.0.pom", match: false},
+		{pattern: "com/acme/lib-?.0.jar", path: "com/acme/lib-1.0.jar", match: true},
+		{pattern: "com/acme/lib-?.0.jar", path: "com/acme/lib-10.0.jar", match: false},
+		{pattern: "com/acme/*", path: "com/acme/lib/1.0", match: false},
+		{pattern: "/{{identity.entity.metadata.team}}/**", path: "team-x/app", match: true},
+		{pattern: "/{{identity.entity.metadata.team}}/**", path: "", match: false},
+		{pattern: "*a*b", path: "aaab", match: true},
+		{pattern: "*a*b", path: "aaba", match: false},
+		{pattern: "lib-*-*.jar", path: "lib-1.0-sources.jar", match: true},
+		{pattern: "**/a/**/b", path: "a/x/a/y/b", match: true},
+		{pattern: "**/a/**/b", path: "a/x/b/y", match: false},
+		{pattern: "**/**/x", path: "x", match: true},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.pattern+"|"+test.path, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.match, antMatch(test.pattern, test.path))
+		})
+	}
+}
+
+func TestAntMatchWorstCase(t *testing.T) {
+	t.Parallel()
+
+	// patterns with many stars which never match used to backtrack exponentially
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+	}{
+		{name: "characters", pattern: "*a*a*a*a*a*a*a*a*a*a*b", path: strings.Repeat("a", 40)},
+		{name: "segments", pattern: "**/**/**/**/**/**/**/**/x", path: strings.Repeat("a/", 40)},
+		{name: "both", pattern: strings.Repeat("**/*a*a*a*/", 8) + "b", path: strings.Repeat(strings.Repeat("a", 40)+"/", 40)},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			start := time.Now()
+			assert.False(t, antMatch(test.pattern, test.path))
+			assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond), "matching took %s", time.Since(start))
+		})
+	}
+}
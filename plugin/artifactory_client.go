@@ -16,33 +16,92 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/go-version"
 	"github.com/jfrog/jfrog-client-go/artifactory"
 	"github.com/jfrog/jfrog-client-go/artifactory/auth"
 	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	jfauth "github.com/jfrog/jfrog-client-go/auth"
 	artconfig "github.com/jfrog/jfrog-client-go/config"
-	"github.com/jfrog/jfrog-client-go/utils/log"
+	"github.com/jfrog/jfrog-client-go/utils/errorutils"
+	"github.com/jfrog/jfrog-client-go/utils/io/httputils"
 )
 
 const (
 	clientTTL = 30 * time.Minute
+
+	// JFrog Access issues scoped tokens and reference tokens from these Artifactory versions on
+	accessTokensMinVersion    = "7.21.1"
+	referenceTokensMinVersion = "7.38.4"
+	accessTokensPath          = "api/v1/tokens"
+	accessProjectsPath        = "api/v1/projects"
+)
+
+var (
+	// errAccessTokensUnavailable is returned when the JFrog Access token API is not served
+	errAccessTokensUnavailable = errors.New("jfrog access token api is not available")
+	// errReferenceTokensUnsupported is returned when a reference token is requested from an Artifactory without them
+	errReferenceTokensUnsupported = fmt.Errorf("reference tokens need JFrog Access on Artifactory %s or above", referenceTokensMinVersion)
+	// errProjectTokensUnsupported is returned when a project role token is requested from an Artifactory without JFrog Access tokens
+	errProjectTokensUnsupported = fmt.Errorf("project roles need JFrog Access tokens on Artifactory %s or above", accessTokensMinVersion)
 )
 
 type Client interface {
-	CreateOrReplaceGroup(role *RoleStorageEntry) error
-	DeleteGroup(role *RoleStorageEntry) error
-	CreateOrUpdatePermissionTarget(role *RoleStorageEntry, pt *PermissionTarget, ptName string) error
-	DeletePermissionTarget(ptName string) error
-	CreateToken(tokenReq TokenCreateEntry, role *RoleStorageEntry) (services.CreateTokenResponseData, error)
+	CreateOrReplaceGroup(ctx context.Context, role *RoleStorageEntry) error
+	DeleteGroup(ctx context.Context, role *RoleStorageEntry) error
+	RemoveGroupFromProject(ctx context.Context, role *RoleStorageEntry, projectKey string) error
+	CreateOrReplaceServiceUser(ctx context.Context, role *RoleStorageEntry) error
+	DeleteServiceUser(ctx context.Context, role *RoleStorageEntry) error
+	CreateOrUpdatePermissionTarget(ctx context.Context, role *RoleStorageEntry, pt *PermissionTarget, ptName string) error
+	DeletePermissionTarget(ctx context.Context, ptName string) error
+	CreateToken(ctx context.Context, tokenReq TokenCreateEntry, role *RoleStorageEntry) (Token, error)
+	SystemInfo(ctx context.Context) (*SystemInfo, error)
+	Ping(ctx context.Context) error
 	Valid() bool
+	Expiration() time.Time
+}
+
+// Token is a token issued by Artifactory
+type Token struct {
+	AccessToken string
+	// ReferenceToken is the short reference to the access token, only issued when requested
+	ReferenceToken string
+	ExpiresIn      int
+	Scope          string
+}
+
+// SystemInfo describes the Artifactory instance a client is connected to
+type SystemInfo struct {
+	Version     string
+	LicenseType string
 }
 
 type artifactoryClient struct {
-	client     artifactory.ArtifactoryServicesManager
+	details    jfauth.ServiceDetails
+	httpClient *http.Client
 	expiration time.Time
+
+	// tokens caches the token API of the Artifactory, nil until detected
+	mu     sync.Mutex
+	tokens *tokenAPI
+}
+
+// tokenAPI is how an Artifactory issues tokens
+type tokenAPI struct {
+	// access is set when tokens are created with JFrog Access rather than the deprecated Artifactory API
+	access bool
+	// reference is set when JFrog Access issues reference tokens
+	reference bool
 }
 
 var _ Client = &artifactoryClient{}
@@ -56,7 +115,7 @@ func NewClient(config *ConfigStorageEntry) (Client, error) {
 		expiration: time.Now().Add(clientTTL),
 	}
 
-	log.SetLogger(log.NewLogger(log.INFO, io.Discard))
+	installJfrogLogger()
 
 	artifactoryDetails := auth.NewArtifactoryDetails()
 	artifactoryDetails.SetUrl(config.BaseURL)
@@ -71,13 +130,44 @@ func NewClient(config *ConfigStorageEntry) (Client, error) {
 	} else {
 		return nil, fmt.Errorf("bearer token, apikey or a pair of username/password isn't configured")
 	}
+	ac.details = artifactoryDetails
+	ac.httpClient = newHTTPClient(config.ClientTimeout)
+
+	// Note: this client is cached between requests, so no Vault request context is bound here.
+	// Each call builds a services manager bound to its own request context instead.
+	if _, err := ac.servicesManager(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return ac, nil
+}
 
-	// Note: do not reuse Vault request context here as this client is cached between requests.
+// newHTTPClient mirrors the jfrog-client-go default transport so that the
+// connection settings can be shared by all services managers of a client.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 20 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
+// servicesManager returns an artifactory services manager whose requests are bound to ctx.
+// It only wraps the shared HTTP client, so building one per call is cheap.
+func (ac *artifactoryClient) servicesManager(ctx context.Context) (artifactory.ArtifactoryServicesManager, error) {
 	artifactoryServiceConfig, err := artconfig.NewConfigBuilder().
-		SetServiceDetails(artifactoryDetails).
-		SetHttpTimeout(config.ClientTimeout).
+		SetServiceDetails(ac.details).
+		SetHttpClient(ac.httpClient).
 		// SetDryRun(false).
-		SetContext(context.Background()).
+		SetContext(ctx).
 		SetThreads(1).
 		Build()
 	if err != nil {
@@ -88,77 +178,435 @@ func NewClient(config *ConfigStorageEntry) (Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to build artifactory client- %v", err.Error())
 	}
-	ac.client = client
 
-	return ac, nil
+	return client, nil
 }
 
 func (ac *artifactoryClient) Valid() bool {
 	return ac != nil && time.Now().Before(ac.expiration)
 }
 
-func (ac *artifactoryClient) CreateOrReplaceGroup(role *RoleStorageEntry) error {
+func (ac *artifactoryClient) Expiration() time.Time {
+	return ac.expiration
+}
+
+func (ac *artifactoryClient) SystemInfo(ctx context.Context) (*SystemInfo, error) {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := client.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	httpDetails := ac.details.CreateHttpClientDetails()
+	resp, body, _, err := client.Client().SendGet(ac.details.GetUrl()+"api/system/license", true, &httpDetails)
+	if err != nil {
+		return nil, err
+	}
+	if err = errorutils.CheckResponseStatus(resp, http.StatusOK); err != nil {
+		return nil, errorutils.GenerateResponseError(resp.Status, string(body))
+	}
+
+	var license struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(body, &license); err != nil {
+		return nil, fmt.Errorf("failed to decode artifactory license - %w", err)
+	}
+
+	return &SystemInfo{
+		Version:     version,
+		LicenseType: license.Type,
+	}, nil
+}
+
+func (ac *artifactoryClient) Ping(ctx context.Context) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Ping()
+	return err
+}
+
+func (ac *artifactoryClient) CreateOrReplaceGroup(ctx context.Context, role *RoleStorageEntry) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
+	}
+
 	params := services.GroupParams{
 		GroupDetails: services.Group{
 			Name: groupName(role),
 		},
 	}
 
-	group, err := ac.client.GetGroup(params)
+	group, err := client.GetGroup(params)
 	if err != nil {
-		return fmt.Errorf("Error fetching a group '%s' - %s", groupName(role), err)
+		return fmt.Errorf("Error fetching a group '%s' - %w", groupName(role), err)
 	}
 	if group != nil {
 		params.ReplaceIfExists = true
 		params.GroupDetails = *group
-		return ac.client.UpdateGroup(params)
+		params.GroupDetails.Description = groupDescription(role)
+		err = client.UpdateGroup(params)
+	} else {
+		autoJoin, adminPrivileges := false, false
+		params.GroupDetails.Description = groupDescription(role)
+		params.GroupDetails.AutoJoin = &autoJoin
+		params.GroupDetails.AdminPrivileges = &adminPrivileges
+		err = client.CreateGroup(params)
+	}
+	if err != nil || role.ProjectKey == "" {
+		return err
+	}
+	return ac.setProjectGroup(client, role)
+}
+
+// projectGroup is the membership of a group in a JFrog project
+type projectGroup struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+func (ac *artifactoryClient) projectGroupURL(projectKey, group string) string {
+	return fmt.Sprintf("%s%s/%s/groups/%s", accessURL(ac.details.GetUrl()), accessProjectsPath, url.PathEscape(projectKey), url.PathEscape(group))
+}
+
+// setProjectGroup makes the group of the role a member of its project with the project roles of the role
+func (ac *artifactoryClient) setProjectGroup(client artifactory.ArtifactoryServicesManager, role *RoleStorageEntry) error {
+	body, err := json.Marshal(projectGroup{Name: groupName(role), Roles: role.ProjectRoles})
+	if err != nil {
+		return err
+	}
+
+	httpDetails := ac.jsonHTTPDetails()
+	resp, respBody, err := client.Client().SendPut(ac.projectGroupURL(role.ProjectKey, groupName(role)), body, &httpDetails)
+	if err != nil {
+		return err
+	}
+	if err = errorutils.CheckResponseStatus(resp, http.StatusOK, http.StatusCreated, http.StatusNoContent); err != nil {
+		return fmt.Errorf("failed to add the group to project '%s' - %w", role.ProjectKey, errorutils.GenerateResponseError(resp.Status, string(respBody)))
+	}
+	return nil
+}
+
+// RemoveGroupFromProject removes the group of the role from the project, e.g. when the role moved to another project
+func (ac *artifactoryClient) RemoveGroupFromProject(ctx context.Context, role *RoleStorageEntry, projectKey string) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
 	}
-	params.GroupDetails.Description = fmt.Sprintf("vault plugin group for %s", role.Name)
-	*params.GroupDetails.AutoJoin = false
-	*params.GroupDetails.AdminPrivileges = false
-	return ac.client.CreateGroup(params)
+
+	httpDetails := ac.details.CreateHttpClientDetails()
+	resp, respBody, err := client.Client().SendDelete(ac.projectGroupURL(projectKey, groupName(role)), nil, &httpDetails)
+	if err != nil {
+		return err
+	}
+	// a group which is not a member, or a deleted project, is fine
+	if err = errorutils.CheckResponseStatus(resp, http.StatusOK, http.StatusNoContent, http.StatusNotFound); err != nil {
+		return errorutils.GenerateResponseError(resp.Status, string(respBody))
+	}
+	return nil
 }
 
-func (ac *artifactoryClient) DeleteGroup(role *RoleStorageEntry) error {
+func (ac *artifactoryClient) DeleteGroup(ctx context.Context, role *RoleStorageEntry) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
+	}
+
 	params := services.GroupParams{
 		GroupDetails: services.Group{
 			Name: groupName(role),
 		},
 	}
-	group, err := ac.client.GetGroup(params)
+	group, err := client.GetGroup(params)
 	if err != nil {
 		return err
 	}
-	if group != nil {
-		return ac.client.DeleteGroup(group.Name)
+	if group == nil {
+		return nil
+	}
+	if role.ProjectKey != "" {
+		if err := ac.RemoveGroupFromProject(ctx, role, role.ProjectKey); err != nil {
+			return err
+		}
+	}
+	return client.DeleteGroup(group.Name)
+}
+
+// CreateOrReplaceServiceUser creates the service user of a role in user mode. Service users can't log
+// in, don't join the auto-join groups and are only used to issue tokens.
+func (ac *artifactoryClient) CreateOrReplaceServiceUser(ctx context.Context, role *RoleStorageEntry) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := services.UserParams{
+		UserDetails: services.User{
+			Name: serviceUserName(role),
+		},
+	}
+	user, err := client.GetUser(params)
+	if err != nil {
+		return fmt.Errorf("Error fetching a user '%s' - %w", serviceUserName(role), err)
+	}
+	if user != nil {
+		return nil
+	}
+
+	// the password is required but never used, as the internal password is disabled
+	password, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	admin, profileUpdatable, disableUIAccess, internalPasswordDisabled := false, false, true, true
+	params.UserDetails.Email = fmt.Sprintf("%s@vault-plugin.invalid", serviceUserName(role))
+	params.UserDetails.Password = "Vp1!" + password
+	params.UserDetails.Admin = &admin
+	params.UserDetails.ProfileUpdatable = &profileUpdatable
+	params.UserDetails.DisableUIAccess = &disableUIAccess
+	params.UserDetails.InternalPasswordDisabled = &internalPasswordDisabled
+	params.UserDetails.Groups = &[]string{}
+	params.ReplaceIfExists = true
+	return client.CreateUser(params)
+}
+
+func (ac *artifactoryClient) DeleteServiceUser(ctx context.Context, role *RoleStorageEntry) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := services.UserParams{
+		UserDetails: services.User{
+			Name: serviceUserName(role),
+		},
+	}
+	user, err := client.GetUser(params)
+	if err != nil {
+		return err
+	}
+	if user != nil {
+		return client.DeleteUser(user.Name)
 	}
 	return nil
 }
 
-func (ac *artifactoryClient) CreateOrUpdatePermissionTarget(role *RoleStorageEntry, pt *PermissionTarget, ptName string) error {
+func (ac *artifactoryClient) CreateOrUpdatePermissionTarget(ctx context.Context, role *RoleStorageEntry, pt *PermissionTarget, ptName string) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
+	}
+
 	params := services.PermissionTargetParams{}
 	convertPermissionTarget(pt, &params, groupName(role), ptName)
+	if role.usesServiceUser() {
+		grantToUser(&params, groupName(role), serviceUserName(role))
+	}
 
-	return ac.client.UpdatePermissionTarget(params)
+	return client.UpdatePermissionTarget(params)
 }
 
-func (ac *artifactoryClient) DeletePermissionTarget(ptName string) error {
-	params, err := ac.client.GetPermissionTarget(ptName)
+func (ac *artifactoryClient) DeletePermissionTarget(ctx context.Context, ptName string) error {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return err
+	}
+
+	params, err := client.GetPermissionTarget(ptName)
 	if err != nil {
 		return err
 	}
 	if params != nil {
-		return ac.client.DeletePermissionTarget(params.Name)
+		return client.DeletePermissionTarget(params.Name)
 	}
 	return nil
 }
 
-func (ac *artifactoryClient) CreateToken(tokenReq TokenCreateEntry, role *RoleStorageEntry) (services.CreateTokenResponseData, error) {
+func (ac *artifactoryClient) CreateToken(ctx context.Context, tokenReq TokenCreateEntry, role *RoleStorageEntry) (Token, error) {
+	client, err := ac.servicesManager(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+
+	api, err := ac.tokenAPI(client)
+	if err != nil {
+		return Token{}, err
+	}
+	if tokenReq.ReferenceToken && !api.reference {
+		return Token{}, errReferenceTokensUnsupported
+	}
+	if role.ProjectKey != "" && !api.access {
+		return Token{}, errProjectTokensUnsupported
+	}
+	if api.access {
+		token, err := ac.createAccessToken(client, tokenReq, role)
+		if !errors.Is(err, errAccessTokensUnavailable) {
+			return token, err
+		}
+		// Access is not served next to Artifactory, stick to the legacy API
+		ac.setTokenAPI(tokenAPI{})
+		if tokenReq.ReferenceToken {
+			return Token{}, errReferenceTokensUnsupported
+		}
+		if role.ProjectKey != "" {
+			return Token{}, errProjectTokensUnsupported
+		}
+	}
+
 	params := services.CreateTokenParams{
 		Scope:     fmt.Sprintf("api:* member-of-groups:%s", groupName(role)),
-		Username:  tokenUsername(role.Name),
+		Username:  roleTokenUsername(role),
 		ExpiresIn: int(tokenReq.TTL.Seconds()),
 	}
+	// service users have the permissions themselves
+	if role.usesServiceUser() {
+		params.Scope = "api:*"
+	}
+
+	token, err := client.CreateToken(params)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{AccessToken: token.AccessToken, ExpiresIn: token.ExpiresIn, Scope: token.Scope}, nil
+}
+
+// tokenAPI returns how the Artifactory issues tokens. It is detected from the Artifactory version
+// once per client.
+func (ac *artifactoryClient) tokenAPI(client artifactory.ArtifactoryServicesManager) (tokenAPI, error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if ac.tokens != nil {
+		return *ac.tokens, nil
+	}
+	v, err := client.GetVersion()
+	if err != nil {
+		return tokenAPI{}, fmt.Errorf("failed to detect the artifactory version - %s", err.Error())
+	}
+	api := tokenAPI{
+		access:    versionAtLeast(v, accessTokensMinVersion),
+		reference: versionAtLeast(v, referenceTokensMinVersion),
+	}
+	ac.tokens = &api
+	return api, nil
+}
+
+func (ac *artifactoryClient) setTokenAPI(api tokenAPI) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.tokens = &api
+}
+
+// versionAtLeast reports whether the Artifactory version is minVersion or above
+func versionAtLeast(artifactoryVersion, minVersion string) bool {
+	v, err := version.NewVersion(artifactoryVersion)
+	if err != nil {
+		return false
+	}
+	return v.GreaterThanOrEqual(version.Must(version.NewVersion(minVersion)))
+}
+
+// accessURL returns the url of JFrog Access, which is served next to Artifactory
+func accessURL(artifactoryURL string) string {
+	return strings.TrimSuffix(appendTrailingSlash(artifactoryURL), "artifactory/") + "access/"
+}
+
+// accessTokenScope scopes tokens to the group of the role, or to its project roles. Roles with both
+// permission targets and project roles get both, and service users their own permissions.
+func accessTokenScope(role *RoleStorageEntry) string {
+	if role.usesServiceUser() {
+		return "applied-permissions/user"
+	}
+	groups := fmt.Sprintf("applied-permissions/groups:%s", groupName(role))
+	if role.ProjectKey == "" {
+		return groups
+	}
+	roles := fmt.Sprintf("applied-permissions/roles:%s:%s", role.ProjectKey, strings.Join(role.ProjectRoles, ","))
+	if len(role.PermissionTargets) == 0 {
+		return roles
+	}
+	return groups + " " + roles
+}
+
+// jsonHTTPDetails returns the client details of a request with a JSON body
+func (ac *artifactoryClient) jsonHTTPDetails() httputils.HttpClientDetails {
+	httpDetails := ac.details.CreateHttpClientDetails()
+	if httpDetails.Headers == nil {
+		httpDetails.Headers = map[string]string{}
+	}
+	httpDetails.Headers["Content-Type"] = "application/json"
+	return httpDetails
+}
+
+// accessTokenRequest is a JFrog Access token creation request
+type accessTokenRequest struct {
+	GrantType             string `json:"grant_type"`
+	Username              string `json:"username"`
+	Scope                 string `json:"scope"`
+	ExpiresIn             int    `json:"expires_in"`
+	Refreshable           bool   `json:"refreshable"`
+	Description           string `json:"description,omitempty"`
+	IncludeReferenceToken bool   `json:"include_reference_token"`
+}
+
+// accessTokenResponse is a token issued by JFrog Access
+type accessTokenResponse struct {
+	TokenID        string `json:"token_id"`
+	AccessToken    string `json:"access_token"`
+	RefreshToken   string `json:"refresh_token"`
+	ExpiresIn      int    `json:"expires_in"`
+	Scope          string `json:"scope"`
+	TokenType      string `json:"token_type"`
+	ReferenceToken string `json:"reference_token"`
+}
+
+// createAccessToken creates a token scoped to the group of the role with the JFrog Access API
+func (ac *artifactoryClient) createAccessToken(client artifactory.ArtifactoryServicesManager, tokenReq TokenCreateEntry, role *RoleStorageEntry) (Token, error) {
+	body, err := json.Marshal(accessTokenRequest{
+		GrantType:   "client_credentials",
+		Username:    roleTokenUsername(role),
+		Scope:       accessTokenScope(role),
+		ExpiresIn:   int(tokenReq.TTL.Seconds()),
+		Description: fmt.Sprintf("vault plugin token for role %s", role.Name),
+		// reference tokens are only returned when asked for
+		IncludeReferenceToken: tokenReq.ReferenceToken,
+	})
+	if err != nil {
+		return Token{}, err
+	}
+
+	httpDetails := ac.jsonHTTPDetails()
+	resp, respBody, err := client.Client().SendPost(accessURL(ac.details.GetUrl())+accessTokensPath, body, &httpDetails)
+	if err != nil {
+		return Token{}, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return Token{}, errAccessTokensUnavailable
+	}
+	if err = errorutils.CheckResponseStatus(resp, http.StatusOK); err != nil {
+		return Token{}, errorutils.GenerateResponseError(resp.Status, string(respBody))
+	}
+
+	var token accessTokenResponse
+	if err := json.Unmarshal(respBody, &token); err != nil {
+		return Token{}, fmt.Errorf("failed to decode jfrog access token - %w", err)
+	}
+
+	if tokenReq.ReferenceToken && token.ReferenceToken == "" {
+		return Token{}, errReferenceTokensUnsupported
+	}
 
-	return ac.client.CreateToken(params)
+	return Token{
+		AccessToken:    token.AccessToken,
+		ReferenceToken: token.ReferenceToken,
+		ExpiresIn:      token.ExpiresIn,
+		Scope:          token.Scope,
+	}, nil
 }
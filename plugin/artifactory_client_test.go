@@ -16,6 +16,9 @@ package artifactorysecrets
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -23,7 +26,6 @@ import (
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/jfrog/jfrog-client-go/artifactory"
-	"github.com/jfrog/jfrog-client-go/artifactory/services"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -107,13 +109,40 @@ func TestArtAccNewClient(t *testing.T) {
 			require.True(ok)
 
 			// call an api endpoint to verify working auth
-			users, err := ac.client.GetAllUsers()
+			client, err := ac.servicesManager(context.Background())
+			require.NoError(err)
+			users, err := client.GetAllUsers()
 			require.NoError(err)
 			require.NotNil(users)
 		})
 	}
 }
 
+func TestClientRequestContext(t *testing.T) {
+	t.Parallel()
+
+	// the server never answers until the request is abandoned by the client
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(&ConfigStorageEntry{
+		BaseURL:     appendTrailingSlash(srv.URL),
+		BearerToken: "mybearertoken",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = c.Ping(ctx)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "expecting request deadline error, got %s", err)
+	assert.Less(t, time.Since(start), 5*time.Second, "request should be cancelled with its context")
+}
+
 func TestValid(t *testing.T) {
 
 	tests := []struct {
@@ -146,6 +175,318 @@ func TestValid(t *testing.T) {
 	}
 }
 
+func TestClientTokenAPI(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		version string
+		// fails the jfrog access token requests with not found
+		accessMissing bool
+		access        bool
+	}{
+		{name: "access", version: "7.38.10", access: true},
+		{name: "access_min_version", version: accessTokensMinVersion, access: true},
+		{name: "legacy", version: "7.17.5"},
+		{name: "legacy_6", version: "6.23.41"},
+		{name: "unknown_version", version: "unknown"},
+		{name: "access_not_served", version: "7.38.10", accessMissing: true},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			fake := newFakeArtifactory(t)
+			fake.setVersion(test.version)
+			if test.accessMissing {
+				fake.fail(http.MethodPost, fakeAccessTokenPath, http.StatusNotFound, -1)
+			}
+			c, err := NewClient(&ConfigStorageEntry{
+				BaseURL:     fake.URL + "/",
+				BearerToken: fakeArtifactoryToken,
+			})
+			require.NoError(t, err)
+			role := &RoleStorageEntry{Name: "ci", RoleID: roleID("ci")}
+			require.NoError(t, c.CreateOrReplaceGroup(ctx, role))
+
+			for i := 0; i < 2; i++ {
+				token, err := c.CreateToken(ctx, TokenCreateEntry{TTL: time.Hour}, role)
+				require.NoError(t, err)
+				assert.NotEmpty(t, token.AccessToken)
+				assert.Equal(t, 3600, token.ExpiresIn)
+			}
+
+			tokens := fake.issuedTokens()
+			require.Len(t, tokens, 2)
+			for _, token := range tokens {
+				assert.Equal(t, test.access, token.Access, "issued by jfrog access")
+				if !test.access {
+					assert.Equal(t, "api:* member-of-groups:"+groupName(role), token.Scope)
+				}
+			}
+			// the token API is detected once per client
+			assert.Equal(t, 1, fake.requestCount(http.MethodGet, "/api/system/version"))
+			if test.accessMissing {
+				assert.Equal(t, 1, fake.requestCount(http.MethodPost, fakeAccessTokenPath))
+			}
+		})
+	}
+}
+
+func TestClientReferenceToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		version       string
+		accessMissing bool
+		err           error
+	}{
+		{name: "issued", version: "7.38.10"},
+		{name: "min_version", version: referenceTokensMinVersion},
+		{name: "access_without_reference_tokens", version: "7.27.3", err: errReferenceTokensUnsupported},
+		{name: "legacy", version: "6.23.41", err: errReferenceTokensUnsupported},
+		{name: "access_not_served", version: "7.38.10", accessMissing: true, err: errReferenceTokensUnsupported},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			fake := newFakeArtifactory(t)
+			fake.setVersion(test.version)
+			if test.accessMissing {
+				fake.fail(http.MethodPost, fakeAccessTokenPath, http.StatusNotFound, -1)
+			}
+			c, err := NewClient(&ConfigStorageEntry{
+				BaseURL:     fake.URL + "/",
+				BearerToken: fakeArtifactoryToken,
+			})
+			require.NoError(t, err)
+			role := &RoleStorageEntry{Name: "ci", RoleID: roleID("ci")}
+			require.NoError(t, c.CreateOrReplaceGroup(ctx, role))
+
+			token, err := c.CreateToken(ctx, TokenCreateEntry{TTL: time.Hour, ReferenceToken: true}, role)
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+				assert.Empty(t, fake.issuedTokens(), "no token must be issued without a reference token")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "fake-access-token-1", token.AccessToken)
+			assert.Equal(t, "fake-reference-token-1", token.ReferenceToken)
+
+			// reference tokens are only issued when asked for
+			token, err = c.CreateToken(ctx, TokenCreateEntry{TTL: time.Hour}, role)
+			require.NoError(t, err)
+			assert.Empty(t, token.ReferenceToken)
+			tokens := fake.issuedTokens()
+			require.Len(t, tokens, 2)
+			assert.True(t, tokens[0].ReferenceToken)
+			assert.False(t, tokens[1].ReferenceToken)
+		})
+	}
+}
+
+func TestAccessTokenScope(t *testing.T) {
+	t.Parallel()
+
+	group := "applied-permissions/groups:" + groupName(&RoleStorageEntry{RoleID: roleID("ci")})
+	pts := []PermissionTarget{{Repo: &Permission{Repositories: []string{"libs-local"}, Operations: []string{"read"}}}}
+	tests := []struct {
+		name     string
+		role     RoleStorageEntry
+		expected string
+	}{
+		{name: "group", role: RoleStorageEntry{PermissionTargets: pts}, expected: group},
+		{name: "project_roles", role: RoleStorageEntry{ProjectKey: "ml", ProjectRoles: []string{"Developer", "Viewer"}}, expected: "applied-permissions/roles:ml:Developer,Viewer"},
+		{name: "both", role: RoleStorageEntry{PermissionTargets: pts, ProjectKey: "ml", ProjectRoles: []string{"Viewer"}}, expected: group + " applied-permissions/roles:ml:Viewer"},
+		{name: "service_user", role: RoleStorageEntry{PermissionTargets: pts, Mode: roleModeUser}, expected: "applied-permissions/user"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			test.role.RoleID = roleID("ci")
+			assert.Equal(t, test.expected, accessTokenScope(&test.role))
+		})
+	}
+}
+
+func TestClientServiceUser(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	fake := newFakeArtifactory(t)
+	c, err := NewClient(&ConfigStorageEntry{
+		BaseURL:     fake.URL + "/",
+		BearerToken: fakeArtifactoryToken,
+	})
+	require.NoError(t, err)
+
+	role := &RoleStorageEntry{Name: "ci", RoleID: roleID("ci"), Mode: roleModeUser}
+	pt := &PermissionTarget{Repo: &Permission{Repositories: []string{"libs-local"}, Operations: []string{"read"}}}
+	ptName := permissionTargetName(role.Name, 0)
+
+	require.NoError(t, c.CreateOrReplaceServiceUser(ctx, role))
+	user, ok := fake.user(serviceUserName(role))
+	require.True(t, ok, "service user not created")
+	require.NotNil(t, user.Groups)
+	assert.Empty(t, *user.Groups, "service user must not join the auto-join groups")
+	require.NotNil(t, user.DisableUIAccess)
+	assert.True(t, *user.DisableUIAccess)
+	require.NotNil(t, user.InternalPasswordDisabled)
+	assert.True(t, *user.InternalPasswordDisabled)
+	require.NotNil(t, user.Admin)
+	assert.False(t, *user.Admin)
+
+	// an existing service user is left alone
+	require.NoError(t, c.CreateOrReplaceServiceUser(ctx, role))
+	assert.Equal(t, 1, fake.requestCount(http.MethodPut, fakeUsersPath))
+
+	require.NoError(t, c.CreateOrUpdatePermissionTarget(ctx, role, pt, ptName))
+	stored, ok := fake.permission(ptName)
+	require.True(t, ok)
+	assert.Empty(t, stored.Repo.Actions.Groups)
+	assert.Equal(t, map[string][]string{serviceUserName(role): {"read"}}, stored.Repo.Actions.Users)
+
+	_, err = c.CreateToken(ctx, TokenCreateEntry{TTL: 10 * time.Minute}, role)
+	require.NoError(t, err)
+	fake.setVersion("7.17.5")
+	legacy, err := NewClient(&ConfigStorageEntry{
+		BaseURL:     fake.URL + "/",
+		BearerToken: fakeArtifactoryToken,
+	})
+	require.NoError(t, err)
+	_, err = legacy.CreateToken(ctx, TokenCreateEntry{TTL: 10 * time.Minute}, role)
+	require.NoError(t, err)
+
+	tokens := fake.issuedTokens()
+	require.Len(t, tokens, 2)
+	assert.Equal(t, serviceUserName(role), tokens[0].Username)
+	assert.Equal(t, "applied-permissions/user", tokens[0].Scope)
+	assert.Equal(t, serviceUserName(role), tokens[1].Username)
+	assert.Equal(t, "api:*", tokens[1].Scope)
+
+	require.NoError(t, c.DeleteServiceUser(ctx, role))
+	_, ok = fake.user(serviceUserName(role))
+	assert.False(t, ok, "service user not deleted")
+	stored, _ = fake.permission(ptName)
+	assert.Empty(t, stored.Repo.Actions.Users)
+
+	// deleting a missing user is not an error
+	require.NoError(t, c.DeleteServiceUser(ctx, role))
+}
+
+func TestAccessURL(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "https://jfrog.example.com/access/", accessURL("https://jfrog.example.com/artifactory/"))
+	assert.Equal(t, "https://jfrog.example.com/access/", accessURL("https://jfrog.example.com/artifactory"))
+	assert.Equal(t, "http://localhost:8082/access/", accessURL("http://localhost:8082/"))
+}
+
+func TestClientFakeArtifactory(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	fake := newFakeArtifactory(t)
+	c, err := NewClient(&ConfigStorageEntry{
+		BaseURL:     fake.URL + "/",
+		BearerToken: fakeArtifactoryToken,
+	})
+	require.NoError(t, err)
+
+	role := &RoleStorageEntry{Name: "ci", RoleID: roleID("ci"), Description: "CI builds"}
+	pt := &PermissionTarget{Repo: &Permission{Repositories: []string{"libs-local"}, Operations: []string{"read"}}}
+	ptName := permissionTargetName(role.Name, 0)
+
+	t.Run("system_info", func(t *testing.T) {
+		require.NoError(t, c.Ping(ctx))
+		info, err := c.SystemInfo(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, fakeArtifactoryVersion, info.Version)
+		assert.Equal(t, "Enterprise", info.LicenseType)
+	})
+
+	t.Run("create_group", func(t *testing.T) {
+		require.NoError(t, c.CreateOrReplaceGroup(ctx, role))
+		group, ok := fake.group(groupName(role))
+		require.True(t, ok)
+		assert.Equal(t, "CI builds", group.Description)
+		require.NotNil(t, group.AutoJoin)
+		assert.False(t, *group.AutoJoin)
+		require.NotNil(t, group.AdminPrivileges)
+		assert.False(t, *group.AdminPrivileges)
+
+		// an existing group is updated
+		updated := *role
+		updated.Description = "CI and release builds"
+		require.NoError(t, c.CreateOrReplaceGroup(ctx, &updated))
+		group, _ = fake.group(groupName(role))
+		assert.Equal(t, "CI and release builds", group.Description)
+		assert.Equal(t, 1, fake.requestCount(http.MethodPost, fakeGroupsPath))
+	})
+
+	t.Run("permission_target", func(t *testing.T) {
+		require.NoError(t, c.CreateOrUpdatePermissionTarget(ctx, role, pt, ptName))
+		stored, ok := fake.permission(ptName)
+		require.True(t, ok)
+		assert.Equal(t, []string{"libs-local"}, stored.Repo.Repositories)
+		assert.Equal(t, map[string][]string{groupName(role): {"read"}}, stored.Repo.Actions.Groups)
+	})
+
+	t.Run("create_token", func(t *testing.T) {
+		token, err := c.CreateToken(ctx, TokenCreateEntry{TTL: 10 * time.Minute}, role)
+		require.NoError(t, err)
+		assert.NotEmpty(t, token.AccessToken)
+		assert.Equal(t, 600, token.ExpiresIn)
+
+		tokens := fake.issuedTokens()
+		require.Len(t, tokens, 1)
+		assert.True(t, tokens[0].Access, "token not issued by jfrog access")
+		assert.Equal(t, tokenUsername(role.Name), tokens[0].Username)
+		assert.Equal(t, "applied-permissions/groups:"+groupName(role), tokens[0].Scope)
+		assert.Equal(t, 600, tokens[0].ExpiresIn)
+		assert.Equal(t, "vault plugin token for role ci", tokens[0].Description)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, c.DeletePermissionTarget(ctx, ptName))
+		require.NoError(t, c.DeleteGroup(ctx, role))
+		groups, pts := fake.counts()
+		assert.Equal(t, 0, groups)
+		assert.Equal(t, 0, pts)
+
+		// deleting missing objects is not an error
+		require.NoError(t, c.DeletePermissionTarget(ctx, ptName))
+		require.NoError(t, c.DeleteGroup(ctx, role))
+	})
+
+	t.Run("missing_group", func(t *testing.T) {
+		err := c.CreateOrUpdatePermissionTarget(ctx, role, pt, ptName)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "400")
+	})
+
+	t.Run("bad_credentials", func(t *testing.T) {
+		bad, err := NewClient(&ConfigStorageEntry{
+			BaseURL:     fake.URL + "/",
+			BearerToken: "wrongtoken",
+		})
+		require.NoError(t, err)
+		err = bad.CreateOrReplaceGroup(ctx, role)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "401")
+	})
+}
+
 type mockArtifactoryClient struct{}
 
 var _ Client = &mockArtifactoryClient{}
@@ -154,21 +495,42 @@ func (ac *mockArtifactoryClient) Valid() bool {
 	return true
 }
 
-func (ac *mockArtifactoryClient) CreateOrReplaceGroup(role *RoleStorageEntry) error {
+func (ac *mockArtifactoryClient) Expiration() time.Time {
+	return time.Now().Add(clientTTL)
+}
+
+func (ac *mockArtifactoryClient) SystemInfo(ctx context.Context) (*SystemInfo, error) {
+	return &SystemInfo{Version: "7.38.10", LicenseType: "Enterprise"}, nil
+}
+
+func (ac *mockArtifactoryClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (ac *mockArtifactoryClient) CreateOrReplaceGroup(ctx context.Context, role *RoleStorageEntry) error {
 	return nil
 }
 
-func (ac *mockArtifactoryClient) DeleteGroup(role *RoleStorageEntry) error {
+func (ac *mockArtifactoryClient) DeleteGroup(ctx context.Context, role *RoleStorageEntry) error {
 	return nil
 }
-func (ac *mockArtifactoryClient) CreateOrUpdatePermissionTarget(role *RoleStorageEntry, pt *PermissionTarget, ptName string) error {
+func (ac *mockArtifactoryClient) RemoveGroupFromProject(ctx context.Context, role *RoleStorageEntry, projectKey string) error {
 	return nil
 }
-func (ac *mockArtifactoryClient) DeletePermissionTarget(ptName string) error {
+func (ac *mockArtifactoryClient) CreateOrReplaceServiceUser(ctx context.Context, role *RoleStorageEntry) error {
 	return nil
 }
-func (ac *mockArtifactoryClient) CreateToken(tokenReq TokenCreateEntry, role *RoleStorageEntry) (services.CreateTokenResponseData, error) {
-	return services.CreateTokenResponseData{}, nil
+func (ac *mockArtifactoryClient) DeleteServiceUser(ctx context.Context, role *RoleStorageEntry) error {
+	return nil
+}
+func (ac *mockArtifactoryClient) CreateOrUpdatePermissionTarget(ctx context.Context, role *RoleStorageEntry, pt *PermissionTarget, ptName string) error {
+	return nil
+}
+func (ac *mockArtifactoryClient) DeletePermissionTarget(ctx context.Context, ptName string) error {
+	return nil
+}
+func (ac *mockArtifactoryClient) CreateToken(ctx context.Context, tokenReq TokenCreateEntry, role *RoleStorageEntry) (Token, error) {
+	return Token{}, nil
 }
 
 // getAccClient returns the underlying artifactory services manager for full access to the Artifactory API.
@@ -179,12 +541,15 @@ func mustGetAccClient(ctx context.Context, t *testing.T, req *logical.Request, b
 	backend, ok := b.(*ArtifactoryBackend)
 	require.True(t, ok, "invalid backend implementation")
 
-	ac, err := backend.getClient(ctx, req.Storage)
+	_, err := backend.getClient(ctx, req.Storage)
 	require.NoError(t, err, "Artifactory client error: %s", err)
 
-	// get the actual Jfrog Client
-	acImpl, ok := ac.(*artifactoryClient)
+	// get the actual Jfrog Client, bypassing the circuit breaker
+	acImpl, ok := backend.client.(*artifactoryClient)
 	require.True(t, ok, "invalid artifactory client implementation")
 
-	return acImpl.client
+	client, err := acImpl.servicesManager(ctx)
+	require.NoError(t, err, "Artifactory services manager error: %s", err)
+
+	return client
 }
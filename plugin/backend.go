@@ -29,8 +29,12 @@ type ArtifactoryBackend struct {
 	*framework.Backend
 	view      logical.Storage
 	client    Client
+	logSink   *jfrogSink
+	breaker   *circuitBreaker
 	lock      sync.RWMutex
 	roleLocks []*locksutil.LockEntry
+	// identity role locks are separate as they are taken while holding role locks
+	identityLocks []*locksutil.LockEntry
 }
 
 func (b *ArtifactoryBackend) getClient(ctx context.Context, s logical.Storage) (Client, error) {
@@ -39,7 +43,7 @@ func (b *ArtifactoryBackend) getClient(ctx context.Context, s logical.Storage) (
 	defer func() { unlockFunc() }()
 
 	if b.client != nil && b.client.Valid() {
-		return b.client, nil
+		return b.breaker.wrap(instrument(b.client)), nil
 	}
 
 	b.lock.RUnlock()
@@ -47,7 +51,7 @@ func (b *ArtifactoryBackend) getClient(ctx context.Context, s logical.Storage) (
 	unlockFunc = b.lock.Unlock
 
 	if b.client != nil && b.client.Valid() {
-		return b.client, nil
+		return b.breaker.wrap(instrument(b.client)), nil
 	}
 
 	config, err := b.getConfig(ctx, s)
@@ -60,8 +64,10 @@ func (b *ArtifactoryBackend) getClient(ctx context.Context, s logical.Storage) (
 		return nil, err
 	}
 	b.client = c
+	b.logSink = configSink(b.Logger(), config)
+	jfrogLog.setSink(b.logSink)
 
-	return c, nil
+	return b.breaker.wrap(instrument(c)), nil
 }
 
 func (b *ArtifactoryBackend) reset() {
@@ -69,11 +75,30 @@ func (b *ArtifactoryBackend) reset() {
 	defer b.lock.Unlock()
 
 	b.client = nil
+	jfrogLog.releaseSink(b.logSink)
+	b.logSink = nil
+	b.breaker.reset()
+}
+
+// periodic removes the identity roles no longer in use
+func (b *ArtifactoryBackend) periodic(ctx context.Context, req *logical.Request) error {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil || config == nil {
+		return err
+	}
+	if err := b.collectIdentityRoles(ctx, req, "", false); err != nil {
+		b.Logger().Warn("unable to remove unused identity roles", "errors", err)
+	}
+	return nil
+}
+
+func (b *ArtifactoryBackend) cleanup(ctx context.Context) {
+	b.reset()
 }
 
 func (b *ArtifactoryBackend) invalidate(ctx context.Context, key string) {
 	switch key {
-	case configPrefix:
+	case configPrefix, configCredentialsKey:
 		b.reset()
 	}
 }
@@ -90,20 +115,38 @@ func Factory(ctx context.Context, c *logical.BackendConfig) (logical.Backend, er
 // Backend export the function to create backend and configure
 func Backend(conf *logical.BackendConfig) *ArtifactoryBackend {
 	backend := &ArtifactoryBackend{
-		view:      conf.StorageView,
-		roleLocks: locksutil.CreateLocks(),
+		view:          conf.StorageView,
+		roleLocks:     locksutil.CreateLocks(),
+		identityLocks: locksutil.CreateLocks(),
+		breaker:       newCircuitBreaker(circuitBreakerThreshold, circuitBreakerCooldown, conf.Logger),
 	}
 
 	backend.Backend = &framework.Backend{
 		BackendType: logical.TypeLogical,
 		Help:        strings.TrimSpace(backendHelp),
+		PathsSpecial: &logical.Paths{
+			SealWrapStorage: []string{
+				configPrefix,
+				configCredentialsKey,
+			},
+		},
 		Paths: framework.PathAppend(
 			pathConfig(backend),
 			pathRole(backend),
 			pathRoleList(backend),
+			pathRoleCopy(backend),
+			pathRoleTemplate(backend),
+			pathRoleBundle(backend),
+			pathRolesApply(backend),
+			pathMigrateNaming(backend),
+			pathQueryAccess(backend),
 			pathToken(backend),
+			pathHealth(backend),
 		),
-		Invalidate: backend.invalidate,
+		InitializeFunc: backend.initialize,
+		Invalidate:     backend.invalidate,
+		Clean:          backend.cleanup,
+		PeriodicFunc:   backend.periodic,
 	}
 
 	return backend
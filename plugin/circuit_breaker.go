@@ -0,0 +1,303 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	circuitBreakerThreshold = 5
+	circuitBreakerCooldown  = 30 * time.Second
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+var errCircuitOpen = errors.New("artifactory is unavailable, circuit breaker is open")
+
+// jfrog-client-go reports unexpected status codes as "Server response: <status>"
+var serverResponseRegex = regexp.MustCompile(`Server response: (\d{3})`)
+
+// circuitBreaker tracks consecutive Artifactory availability failures. Once the
+// threshold is reached, calls fail fast until a background probe succeeds.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       circuitState
+	failures    int
+	threshold   int
+	cooldown    time.Duration
+	openedAt    time.Time
+	lastError   error
+	lastSuccess time.Time
+	stopCh      chan struct{}
+	logger      hclog.Logger
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, logger hclog.Logger) *circuitBreaker {
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		logger:    logger,
+	}
+}
+
+// wrap returns a Client whose calls are guarded by the circuit breaker
+func (cb *circuitBreaker) wrap(client Client) Client {
+	return &circuitBreakerClient{Client: client, breaker: cb}
+}
+
+// call runs fn unless the circuit is open and records its outcome
+func (cb *circuitBreaker) call(ctx context.Context, client Client, fn func() error) error {
+	cb.mu.Lock()
+	if cb.state != circuitClosed {
+		lastErr := cb.lastError
+		cb.mu.Unlock()
+		return fmt.Errorf("%w - last error: %v", errCircuitOpen, lastErr)
+	}
+	cb.mu.Unlock()
+
+	err := fn()
+	cb.record(ctx, client, err)
+	return err
+}
+
+func (cb *circuitBreaker) record(ctx context.Context, client Client, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil {
+		cb.lastSuccess = time.Now()
+	}
+	if !isUnavailableError(ctx, err) {
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	cb.lastError = err
+	if cb.state == circuitClosed && cb.failures >= cb.threshold {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+		cb.stopCh = make(chan struct{})
+		cb.logger.Warn("artifactory circuit breaker opened", "failures", cb.failures, "error", err)
+		go cb.probe(client, cb.stopCh)
+	}
+}
+
+// probe periodically pings Artifactory while the circuit is open and closes it
+// as soon as Artifactory responds again.
+func (cb *circuitBreaker) probe(client Client, stopCh chan struct{}) {
+	ticker := time.NewTicker(cb.cooldown)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		cb.mu.Lock()
+		cb.state = circuitHalfOpen
+		cb.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), cb.cooldown)
+		err := client.Ping(ctx)
+		cancel()
+
+		cb.mu.Lock()
+		select {
+		case <-stopCh:
+			// breaker was reset while probing
+			cb.mu.Unlock()
+			return
+		default:
+		}
+		if err == nil {
+			cb.state = circuitClosed
+			cb.failures = 0
+			cb.lastSuccess = time.Now()
+			cb.stopCh = nil
+			cb.mu.Unlock()
+			cb.logger.Info("artifactory circuit breaker closed")
+			return
+		}
+		cb.state = circuitOpen
+		cb.lastError = err
+		cb.mu.Unlock()
+		cb.logger.Debug("artifactory is still unavailable", "error", err)
+	}
+}
+
+// reset closes the circuit and stops any running probe
+func (cb *circuitBreaker) reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.stopCh != nil {
+		close(cb.stopCh)
+		cb.stopCh = nil
+	}
+	cb.state = circuitClosed
+	cb.failures = 0
+	cb.lastError = nil
+	cb.openedAt = time.Time{}
+}
+
+// lastSuccessfulCall returns when Artifactory last answered a call successfully
+func (cb *circuitBreaker) lastSuccessfulCall() time.Time {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.lastSuccess
+}
+
+func (cb *circuitBreaker) status() map[string]interface{} {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := map[string]interface{}{
+		"state":                cb.state.String(),
+		"consecutive_failures": cb.failures,
+	}
+	if !cb.openedAt.IsZero() && cb.state != circuitClosed {
+		status["opened_at"] = cb.openedAt.Format(time.RFC3339)
+	}
+	if cb.lastError != nil {
+		status["last_error"] = cb.lastError.Error()
+	}
+	return status
+}
+
+// isUnavailableError reports whether err means Artifactory could not serve the
+// request at all, as opposed to rejecting it. ctx is the context of the request.
+func isUnavailableError(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	// a caller giving up says nothing about Artifactory
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		// only a transport timeout counts, not the request running out of time
+		return ctx.Err() == nil
+	}
+	if m := serverResponseRegex.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code >= 500
+	}
+	return false
+}
+
+type circuitBreakerClient struct {
+	Client
+	breaker *circuitBreaker
+}
+
+var _ Client = &circuitBreakerClient{}
+
+func (c *circuitBreakerClient) CreateOrReplaceGroup(ctx context.Context, role *RoleStorageEntry) error {
+	return c.breaker.call(ctx, c.Client, func() error {
+		return c.Client.CreateOrReplaceGroup(ctx, role)
+	})
+}
+
+func (c *circuitBreakerClient) DeleteGroup(ctx context.Context, role *RoleStorageEntry) error {
+	return c.breaker.call(ctx, c.Client, func() error {
+		return c.Client.DeleteGroup(ctx, role)
+	})
+}
+
+func (c *circuitBreakerClient) RemoveGroupFromProject(ctx context.Context, role *RoleStorageEntry, projectKey string) error {
+	return c.breaker.call(ctx, c.Client, func() error {
+		return c.Client.RemoveGroupFromProject(ctx, role, projectKey)
+	})
+}
+
+func (c *circuitBreakerClient) CreateOrReplaceServiceUser(ctx context.Context, role *RoleStorageEntry) error {
+	return c.breaker.call(ctx, c.Client, func() error {
+		return c.Client.CreateOrReplaceServiceUser(ctx, role)
+	})
+}
+
+func (c *circuitBreakerClient) DeleteServiceUser(ctx context.Context, role *RoleStorageEntry) error {
+	return c.breaker.call(ctx, c.Client, func() error {
+		return c.Client.DeleteServiceUser(ctx, role)
+	})
+}
+
+func (c *circuitBreakerClient) CreateOrUpdatePermissionTarget(ctx context.Context, role *RoleStorageEntry, pt *PermissionTarget, ptName string) error {
+	return c.breaker.call(ctx, c.Client, func() error {
+		return c.Client.CreateOrUpdatePermissionTarget(ctx, role, pt, ptName)
+	})
+}
+
+func (c *circuitBreakerClient) DeletePermissionTarget(ctx context.Context, ptName string) error {
+	return c.breaker.call(ctx, c.Client, func() error {
+		return c.Client.DeletePermissionTarget(ctx, ptName)
+	})
+}
+
+func (c *circuitBreakerClient) SystemInfo(ctx context.Context) (*SystemInfo, error) {
+	var info *SystemInfo
+	err := c.breaker.call(ctx, c.Client, func() error {
+		var err error
+		info, err = c.Client.SystemInfo(ctx)
+		return err
+	})
+	return info, err
+}
+
+func (c *circuitBreakerClient) CreateToken(ctx context.Context, tokenReq TokenCreateEntry, role *RoleStorageEntry) (Token, error) {
+	var token Token
+	err := c.breaker.call(ctx, c.Client, func() error {
+		var err error
+		token, err = c.Client.CreateToken(ctx, tokenReq, role)
+		return err
+	})
+	return token, err
+}
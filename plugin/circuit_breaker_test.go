@@ -0,0 +1,204 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUnavailableError(t *testing.T) {
+	t.Parallel()
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		asserter assert.BoolAssertionFunc
+	}{
+		{
+			name:     "nil",
+			err:      nil,
+			asserter: assert.False,
+		},
+		{
+			name:     "connection refused",
+			err:      &url.Error{Op: "Get", URL: "https://example.jfrog.io", Err: errors.New("connection refused")},
+			asserter: assert.True,
+		},
+		{
+			name:     "deadline exceeded",
+			err:      context.DeadlineExceeded,
+			asserter: assert.True,
+		},
+		{
+			name:     "request deadline exceeded",
+			ctx:      expired,
+			err:      &url.Error{Op: "Get", URL: "https://example.jfrog.io", Err: context.DeadlineExceeded},
+			asserter: assert.False,
+		},
+		{
+			name:     "connection refused after request deadline",
+			ctx:      expired,
+			err:      &url.Error{Op: "Get", URL: "https://example.jfrog.io", Err: errors.New("connection refused")},
+			asserter: assert.False,
+		},
+		{
+			name:     "server error after request deadline",
+			ctx:      expired,
+			err:      errors.New("Server response: 503 Service Unavailable\n"),
+			asserter: assert.True,
+		},
+		{
+			name:     "caller cancelled",
+			err:      &url.Error{Op: "Get", URL: "https://example.jfrog.io", Err: context.Canceled},
+			asserter: assert.False,
+		},
+		{
+			name:     "server error",
+			err:      errors.New("Server response: 503 Service Unavailable\n"),
+			asserter: assert.True,
+		},
+		{
+			name:     "client error",
+			err:      errors.New("Server response: 400 Bad Request\nnon-existing repository"),
+			asserter: assert.False,
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := test.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			test.asserter(t, isUnavailableError(ctx, test.err))
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	role := &RoleStorageEntry{Name: "test_role", RoleID: roleID("test_role")}
+	unavailable := errors.New("Server response: 502 Bad Gateway\n")
+
+	t.Run("opens_after_threshold", func(t *testing.T) {
+		t.Parallel()
+
+		inner := newRecordingClient()
+		inner.failAll(unavailable)
+		cb := newCircuitBreaker(3, time.Hour, nil)
+		defer cb.reset()
+		c := cb.wrap(inner)
+
+		for i := 0; i < 3; i++ {
+			err := c.CreateOrReplaceGroup(ctx, role)
+			assert.Equal(t, unavailable, err)
+		}
+		assert.Equal(t, "open", cb.status()["state"])
+
+		err := c.CreateOrReplaceGroup(ctx, role)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, errCircuitOpen))
+		assert.Equal(t, 3, inner.callCount(callCreateGroup), "open circuit should not call Artifactory")
+	})
+
+	t.Run("ignores_rejected_requests", func(t *testing.T) {
+		t.Parallel()
+
+		inner := newRecordingClient()
+		inner.failAll(errors.New("Server response: 404 Not Found\n"))
+		cb := newCircuitBreaker(2, time.Hour, nil)
+		c := cb.wrap(inner)
+
+		for i := 0; i < 5; i++ {
+			_ = c.CreateOrReplaceGroup(ctx, role)
+		}
+		assert.Equal(t, "closed", cb.status()["state"])
+		assert.Equal(t, 5, inner.callCount(callCreateGroup))
+	})
+
+	t.Run("recovers_in_background", func(t *testing.T) {
+		t.Parallel()
+
+		inner := newRecordingClient()
+		inner.failAll(unavailable)
+		cb := newCircuitBreaker(1, 10*time.Millisecond, nil)
+		defer cb.reset()
+		c := cb.wrap(inner)
+
+		_ = c.CreateOrReplaceGroup(ctx, role)
+		require.NotEqual(t, "closed", cb.status()["state"])
+
+		inner.failAll(nil)
+		require.Eventually(t, func() bool {
+			return cb.status()["state"] == "closed"
+		}, time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, c.CreateOrReplaceGroup(ctx, role))
+	})
+
+	t.Run("ignores_request_deadlines", func(t *testing.T) {
+		t.Parallel()
+
+		inner := newRecordingClient()
+		inner.failAll(context.DeadlineExceeded)
+		cb := newCircuitBreaker(2, time.Hour, nil)
+		defer cb.reset()
+		c := cb.wrap(inner)
+
+		expired, cancel := context.WithTimeout(ctx, 0)
+		defer cancel()
+		for i := 0; i < 3; i++ {
+			_ = c.CreateOrReplaceGroup(expired, role)
+		}
+		assert.Equal(t, "closed", cb.status()["state"])
+		assert.Equal(t, 0, cb.status()["consecutive_failures"])
+
+		for i := 0; i < 2; i++ {
+			_ = c.CreateOrReplaceGroup(ctx, role)
+		}
+		assert.Equal(t, "open", cb.status()["state"], "transport timeouts should open the circuit")
+	})
+
+	t.Run("reset_closes_circuit", func(t *testing.T) {
+		t.Parallel()
+
+		inner := newRecordingClient()
+		inner.failAll(unavailable)
+		cb := newCircuitBreaker(1, time.Hour, nil)
+		c := cb.wrap(inner)
+
+		_ = c.CreateOrReplaceGroup(ctx, role)
+		require.Equal(t, "open", cb.status()["state"])
+
+		cb.reset()
+		assert.Equal(t, "closed", cb.status()["state"])
+		assert.Equal(t, 0, cb.status()["consecutive_failures"])
+	})
+}
@@ -16,39 +16,205 @@ package artifactorysecrets
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
 	configPrefix = "config"
+	// the credentials of the config are stored apart from it, both entries are seal wrapped
+	configCredentialsKey = configPrefix + "/credentials"
 )
 
+// length of the credential fingerprints shown by config reads, in hex characters
+const credentialFingerprintLen = 16
+
+// configCredentials is the secret part of the config as it is stored within vault
+type configCredentials struct {
+	BearerToken string `json:"bearer_token"`
+	ApiKey      string `json:"api_key"`
+	Password    string `json:"password"`
+	// HMAC key of the credential fingerprints, generated once per mount
+	FingerprintKey string `json:"fingerprint_key"`
+}
+
 // ConfigStorageEntry structure represents the config as it is stored within vault
 type ConfigStorageEntry struct {
+	SchemaVersion int           `json:"schema_version" structs:"schema_version" mapstructure:"schema_version"`
 	BaseURL       string        `json:"base_url" structs:"base_url" mapstructure:"base_url"`
-	BearerToken   string        `json:"bearer_token" structs:"bearer_token" mapstructure:"bearer_token"`
+	BearerToken   string        `json:"-" structs:"-" mapstructure:"-"`
 	Username      string        `json:"username" structs:"username" mapstructure:"username"`
-	Password      string        `json:"password" structs:"password" mapstructure:"password"`
-	ApiKey        string        `json:"api_key" structs:"api_key" mapstructure:"api_key"`
+	Password      string        `json:"-" structs:"-" mapstructure:"-"`
+	ApiKey        string        `json:"-" structs:"-" mapstructure:"-"`
 	MaxTTL        time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
 	ClientTimeout time.Duration `json:"client_timeout" structs:"client_timeout" mapstructure:"client_timeout"`
+	LogLevel      string        `json:"log_level" structs:"log_level" mapstructure:"log_level"`
+
+	// How new roles name their Artifactory objects, the default naming when unset
+	NamePrefix                   string `json:"name_prefix,omitempty" structs:"name_prefix" mapstructure:"name_prefix"`
+	GroupNameTemplate            string `json:"group_name_template,omitempty" structs:"group_name_template" mapstructure:"group_name_template"`
+	PermissionTargetNameTemplate string `json:"permission_target_name_template,omitempty" structs:"permission_target_name_template" mapstructure:"permission_target_name_template"`
+	TokenUsernameTemplate        string `json:"token_username_template,omitempty" structs:"token_username_template" mapstructure:"token_username_template"`
+
+	// stored with the credentials
+	FingerprintKey string `json:"-" structs:"-" mapstructure:"-"`
+}
+
+func (cfg *ConfigStorageEntry) credentials() configCredentials {
+	return configCredentials{
+		BearerToken:    cfg.BearerToken,
+		ApiKey:         cfg.ApiKey,
+		Password:       cfg.Password,
+		FingerprintKey: cfg.FingerprintKey,
+	}
+}
+
+func (cfg *ConfigStorageEntry) setCredentials(creds configCredentials) {
+	cfg.BearerToken = creds.BearerToken
+	cfg.ApiKey = creds.ApiKey
+	cfg.Password = creds.Password
+	cfg.FingerprintKey = creds.FingerprintKey
+}
+
+// naming returns the naming of the roles created with the config
+func (cfg *ConfigStorageEntry) naming() Naming {
+	return Naming{
+		Prefix:                   cfg.NamePrefix,
+		GroupTemplate:            cfg.GroupNameTemplate,
+		PermissionTargetTemplate: cfg.PermissionTargetNameTemplate,
+		TokenUsernameTemplate:    cfg.TokenUsernameTemplate,
+	}.withDefaults()
+}
+
+// roleNaming returns the naming to store on roles, none for the default naming
+func (cfg *ConfigStorageEntry) roleNaming() *Naming {
+	naming := cfg.naming()
+	if naming == defaultNaming {
+		return nil
+	}
+	return &naming
+}
+
+// authMethod returns the credential type used to authenticate against Artifactory.
+// It follows the same precedence as NewClient.
+func (cfg *ConfigStorageEntry) authMethod() string {
+	switch {
+	case cfg.BearerToken != "":
+		return "bearer_token"
+	case cfg.ApiKey != "":
+		return "api_key"
+	case cfg.Username != "" && cfg.Password != "":
+		return "username_password"
+	default:
+		return "none"
+	}
+}
+
+// credentialFingerprint identifies the credential used to authenticate against Artifactory
+// without revealing it, so its rotation can be checked. It is empty when there is none.
+func (cfg *ConfigStorageEntry) credentialFingerprint() string {
+	var secret string
+	switch cfg.authMethod() {
+	case "bearer_token":
+		secret = cfg.BearerToken
+	case "api_key":
+		secret = cfg.ApiKey
+	case "username_password":
+		secret = cfg.Username + ":" + cfg.Password
+	default:
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.FingerprintKey))
+	mac.Write([]byte(secret))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))[:credentialFingerprintLen]
 }
 
 func (backend *ArtifactoryBackend) getConfig(ctx context.Context, s logical.Storage) (*ConfigStorageEntry, error) {
+	cfg, _, err := readConfig(ctx, s)
+	return cfg, err
+}
+
+// readConfig reads the config with its credentials, upgrading it when it is from an older schema,
+// and describes the upgrades applied
+func readConfig(ctx context.Context, s logical.Storage) (*ConfigStorageEntry, []string, error) {
 	var cfg ConfigStorageEntry
 	cfgRaw, err := s.Get(ctx, configPrefix)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if cfgRaw == nil {
-		return nil, nil
+		return nil, nil, nil
+	}
+
+	upgraded, changes, err := decodeUpgradedJSON(cfgRaw, upgradeConfigRecord, &cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	creds, err := getConfigCredentials(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if creds == nil && upgraded != nil {
+		// older releases stored the credentials with the config, the upgrade moves them aside
+		var legacy struct {
+			Credentials *configCredentials `json:"credentials"`
+		}
+		if err := jsonutil.DecodeJSON(upgraded, &legacy); err != nil {
+			return nil, nil, err
+		}
+		creds = legacy.Credentials
+	}
+	if creds != nil {
+		cfg.setCredentials(*creds)
 	}
 
-	if err := cfgRaw.DecodeJSON(&cfg); err != nil {
+	return &cfg, changes, nil
+}
+
+func getConfigCredentials(ctx context.Context, s logical.Storage) (*configCredentials, error) {
+	entry, err := s.Get(ctx, configCredentialsKey)
+	if err != nil || entry == nil {
 		return nil, err
 	}
 
-	return &cfg, err
+	var creds configCredentials
+	if err := entry.DecodeJSON(&creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// putConfig stores the config and, apart from it, its credentials. The credentials go first so an
+// interrupted write never leaves the config without them.
+func putConfig(ctx context.Context, s logical.Storage, cfg *ConfigStorageEntry) error {
+	if cfg.FingerprintKey == "" {
+		key, err := uuid.GenerateRandomBytes(32)
+		if err != nil {
+			return err
+		}
+		cfg.FingerprintKey = hex.EncodeToString(key)
+	}
+	cfg.SchemaVersion = configSchemaVersion
+
+	credsEntry, err := logical.StorageEntryJSON(configCredentialsKey, cfg.credentials())
+	if err != nil {
+		return err
+	}
+	entry, err := logical.StorageEntryJSON(configPrefix, cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Put(ctx, credsEntry); err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
 }
@@ -0,0 +1,541 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/jfrog-client-go/artifactory/services"
+)
+
+const (
+	fakeArtifactoryToken   = "fakebearertoken"
+	fakeArtifactoryVersion = "7.38.10"
+
+	fakeGroupsPath      = "/api/security/groups/"
+	fakeUsersPath       = "/api/security/users/"
+	fakePermissionsPath = "/api/v2/security/permissions/"
+	fakeTokenPath       = "/api/security/token"
+	fakeAccessTokenPath = "/access/api/v1/tokens"
+	fakeProjectsPath    = "/access/api/v1/projects/"
+)
+
+// fakeToken is a token issued by the fake Artifactory
+type fakeToken struct {
+	// Access is set for tokens issued by the JFrog Access API
+	Access      bool
+	Username    string
+	Scope       string
+	ExpiresIn   int
+	Description string
+	// ReferenceToken is set when a reference token was issued with the token
+	ReferenceToken bool
+}
+
+// fakeFault makes the fake Artifactory answer requests matching method and path prefix with status
+type fakeFault struct {
+	method string
+	path   string
+	status int
+	// remaining number of requests to fail, negative fails forever
+	times int
+}
+
+// fakeArtifactory is an in-process fake of the Artifactory REST APIs used by the plugin. It keeps
+// groups, users, permission targets and tokens in memory and can be told to fail requests.
+type fakeArtifactory struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	version     string
+	groups      map[string]services.Group
+	users       map[string]services.User
+	permissions map[string]services.PermissionTargetParams
+	// projects holds the project roles of the member groups of each project
+	projects map[string]map[string][]string
+	tokens   []fakeToken
+	faults   []*fakeFault
+	requests []string
+}
+
+func newFakeArtifactory(t *testing.T) *fakeArtifactory {
+	t.Helper()
+
+	fake := &fakeArtifactory{
+		version:     fakeArtifactoryVersion,
+		groups:      map[string]services.Group{},
+		users:       map[string]services.User{},
+		permissions: map[string]services.PermissionTargetParams{},
+		projects:    map[string]map[string][]string{},
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// config returns the plugin config pointing at the fake
+func (fake *fakeArtifactory) config() map[string]interface{} {
+	return map[string]interface{}{
+		"base_url":     fake.URL + "/",
+		"bearer_token": fakeArtifactoryToken,
+	}
+}
+
+// fail answers the next times requests matching method and path prefix with status,
+// or every request when times is negative
+func (fake *fakeArtifactory) fail(method, path string, status, times int) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.faults = append(fake.faults, &fakeFault{method: method, path: path, status: status, times: times})
+}
+
+// setVersion changes the reported version, JFrog Access tokens are only served from 7.21.1 on
+// and reference tokens from 7.38.4 on
+func (fake *fakeArtifactory) setVersion(version string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.version = version
+}
+
+// addProject creates a JFrog project without members
+func (fake *fakeArtifactory) addProject(projectKey string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.projects[projectKey] = map[string][]string{}
+}
+
+// projectRoles returns the project roles of a member group of the project
+func (fake *fakeArtifactory) projectRoles(projectKey, group string) ([]string, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	roles, ok := fake.projects[projectKey][group]
+	return roles, ok
+}
+
+func (fake *fakeArtifactory) group(name string) (services.Group, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	g, ok := fake.groups[name]
+	return g, ok
+}
+
+func (fake *fakeArtifactory) user(name string) (services.User, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	u, ok := fake.users[name]
+	return u, ok
+}
+
+func (fake *fakeArtifactory) permission(name string) (services.PermissionTargetParams, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	pt, ok := fake.permissions[name]
+	return pt, ok
+}
+
+func (fake *fakeArtifactory) counts() (int, int) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return len(fake.groups), len(fake.permissions)
+}
+
+func (fake *fakeArtifactory) issuedTokens() []fakeToken {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return append([]fakeToken{}, fake.tokens...)
+}
+
+func (fake *fakeArtifactory) requestCount(method, path string) int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	n := 0
+	for _, r := range fake.requests {
+		if strings.HasPrefix(r, method+" "+path) {
+			n++
+		}
+	}
+	return n
+}
+
+func (fake *fakeArtifactory) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.requests = append(fake.requests, r.Method+" "+r.URL.Path)
+
+	for _, f := range fake.faults {
+		if f.times != 0 && f.method == r.Method && strings.HasPrefix(r.URL.Path, f.path) {
+			if f.times > 0 {
+				f.times--
+			}
+			fakeError(w, f.status, "injected fault")
+			return
+		}
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+fakeArtifactoryToken {
+		fakeError(w, http.StatusUnauthorized, "Bad credentials")
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/api/system/ping" && r.Method == http.MethodGet:
+		fmt.Fprint(w, "OK")
+	case path == "/api/system/version" && r.Method == http.MethodGet:
+		fakeJSON(w, http.StatusOK, map[string]string{"version": fake.version, "revision": "73810900"})
+	case path == "/api/system/license" && r.Method == http.MethodGet:
+		fakeJSON(w, http.StatusOK, map[string]string{"type": "Enterprise"})
+	case strings.HasPrefix(path, fakeGroupsPath):
+		fake.serveGroup(w, r, strings.TrimPrefix(path, fakeGroupsPath))
+	case strings.HasPrefix(path, fakeUsersPath):
+		fake.serveUser(w, r, strings.TrimPrefix(path, fakeUsersPath))
+	case strings.HasPrefix(path, fakePermissionsPath):
+		fake.servePermission(w, r, strings.TrimPrefix(path, fakePermissionsPath))
+	case path == fakeTokenPath && r.Method == http.MethodPost:
+		fake.serveToken(w, r)
+	case path == fakeAccessTokenPath && r.Method == http.MethodPost && versionAtLeast(fake.version, accessTokensMinVersion):
+		fake.serveAccessToken(w, r)
+	case strings.HasPrefix(path, fakeProjectsPath) && versionAtLeast(fake.version, accessTokensMinVersion):
+		fake.serveProjectGroup(w, r, strings.TrimPrefix(path, fakeProjectsPath))
+	default:
+		fakeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (fake *fakeArtifactory) serveGroup(w http.ResponseWriter, r *http.Request, name string) {
+	existing, exists := fake.groups[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("Group '%s' not found", name))
+			return
+		}
+		fakeJSON(w, http.StatusOK, existing)
+	case http.MethodPut, http.MethodPost:
+		if r.Method == http.MethodPost && !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("Group '%s' not found", name))
+			return
+		}
+		var group services.Group
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			fakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		group.Name = name
+		fake.groups[name] = group
+		status := http.StatusCreated
+		if exists {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+	case http.MethodDelete:
+		if !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("Group '%s' not found", name))
+			return
+		}
+		delete(fake.groups, name)
+		// like Artifactory, the group loses its permissions and project memberships
+		for _, members := range fake.projects {
+			delete(members, name)
+		}
+		for ptName, pt := range fake.permissions {
+			for _, section := range []*services.PermissionTargetSection{pt.Repo, pt.Build} {
+				if section != nil && section.Actions != nil {
+					delete(section.Actions.Groups, name)
+				}
+			}
+			fake.permissions[ptName] = pt
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+func (fake *fakeArtifactory) serveUser(w http.ResponseWriter, r *http.Request, name string) {
+	existing, exists := fake.users[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("User '%s' not found", name))
+			return
+		}
+		// like Artifactory, the password is never returned
+		existing.Password = ""
+		fakeJSON(w, http.StatusOK, existing)
+	case http.MethodPut, http.MethodPost:
+		if r.Method == http.MethodPost && !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("User '%s' not found", name))
+			return
+		}
+		var user services.User
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			fakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.Method == http.MethodPut && (user.Email == "" || user.Password == "") {
+			fakeError(w, http.StatusBadRequest, "email and password are required")
+			return
+		}
+		user.Name = name
+		fake.users[name] = user
+		status := http.StatusCreated
+		if exists {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+	case http.MethodDelete:
+		if !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("User '%s' not found", name))
+			return
+		}
+		delete(fake.users, name)
+		// like Artifactory, the user loses its permissions
+		for ptName, pt := range fake.permissions {
+			for _, section := range []*services.PermissionTargetSection{pt.Repo, pt.Build} {
+				if section != nil && section.Actions != nil {
+					delete(section.Actions.Users, name)
+				}
+			}
+			fake.permissions[ptName] = pt
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+func (fake *fakeArtifactory) servePermission(w http.ResponseWriter, r *http.Request, name string) {
+	existing, exists := fake.permissions[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("Permission target '%s' not found", name))
+			return
+		}
+		fakeJSON(w, http.StatusOK, existing)
+	case http.MethodPut, http.MethodPost:
+		if r.Method == http.MethodPost && exists {
+			fakeError(w, http.StatusConflict, fmt.Sprintf("Permission target '%s' already exists", name))
+			return
+		}
+		var pt services.PermissionTargetParams
+		if err := json.NewDecoder(r.Body).Decode(&pt); err != nil {
+			fakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, section := range []*services.PermissionTargetSection{pt.Repo, pt.Build} {
+			if section == nil || section.Actions == nil {
+				continue
+			}
+			for group := range section.Actions.Groups {
+				if _, ok := fake.groups[group]; !ok {
+					fakeError(w, http.StatusBadRequest, fmt.Sprintf("Group '%s' does not exist", group))
+					return
+				}
+			}
+			for user := range section.Actions.Users {
+				if _, ok := fake.users[user]; !ok {
+					fakeError(w, http.StatusBadRequest, fmt.Sprintf("User '%s' does not exist", user))
+					return
+				}
+			}
+		}
+		pt.Name = name
+		fake.permissions[name] = pt
+		status := http.StatusCreated
+		if exists {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+	case http.MethodDelete:
+		if !exists {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("Permission target '%s' not found", name))
+			return
+		}
+		delete(fake.permissions, name)
+		w.WriteHeader(http.StatusOK)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+func (fake *fakeArtifactory) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		fakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	username := r.PostForm.Get("username")
+	scope := r.PostForm.Get("scope")
+	if username == "" {
+		fakeError(w, http.StatusBadRequest, "username is required")
+		return
+	}
+	for _, s := range strings.Fields(scope) {
+		if !strings.HasPrefix(s, "member-of-groups:") {
+			continue
+		}
+		if !fake.groupsExist(w, strings.TrimPrefix(s, "member-of-groups:")) {
+			return
+		}
+	}
+	expiresIn, _ := strconv.Atoi(r.PostForm.Get("expires_in"))
+
+	fake.tokens = append(fake.tokens, fakeToken{Username: username, Scope: scope, ExpiresIn: expiresIn})
+	fakeJSON(w, http.StatusOK, services.CreateTokenResponseData{
+		Scope:       scope,
+		AccessToken: fmt.Sprintf("fake-access-token-%d", len(fake.tokens)),
+		ExpiresIn:   expiresIn,
+		TokenType:   "Bearer",
+	})
+}
+
+func (fake *fakeArtifactory) serveAccessToken(w http.ResponseWriter, r *http.Request) {
+	var tokenReq accessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&tokenReq); err != nil {
+		fakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if tokenReq.Username == "" {
+		fakeError(w, http.StatusBadRequest, "username is required")
+		return
+	}
+	for _, scope := range strings.Fields(tokenReq.Scope) {
+		switch {
+		case scope == "applied-permissions/user":
+			if _, ok := fake.users[tokenReq.Username]; !ok {
+				fakeError(w, http.StatusBadRequest, fmt.Sprintf("User '%s' does not exist", tokenReq.Username))
+				return
+			}
+		case strings.HasPrefix(scope, "applied-permissions/groups:"):
+			if !fake.groupsExist(w, strings.TrimPrefix(scope, "applied-permissions/groups:")) {
+				return
+			}
+		case strings.HasPrefix(scope, "applied-permissions/roles:"):
+			projectKey := strings.SplitN(strings.TrimPrefix(scope, "applied-permissions/roles:"), ":", 2)[0]
+			if _, ok := fake.projects[projectKey]; !ok {
+				fakeError(w, http.StatusBadRequest, fmt.Sprintf("Project '%s' does not exist", projectKey))
+				return
+			}
+		default:
+			fakeError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported scope '%s'", scope))
+			return
+		}
+	}
+
+	// like Artifactory, older versions ignore include_reference_token
+	reference := tokenReq.IncludeReferenceToken && versionAtLeast(fake.version, referenceTokensMinVersion)
+	fake.tokens = append(fake.tokens, fakeToken{
+		Access:         true,
+		Username:       tokenReq.Username,
+		Scope:          tokenReq.Scope,
+		ExpiresIn:      tokenReq.ExpiresIn,
+		Description:    tokenReq.Description,
+		ReferenceToken: reference,
+	})
+	resp := accessTokenResponse{
+		TokenID:     fmt.Sprintf("fake-token-id-%d", len(fake.tokens)),
+		AccessToken: fmt.Sprintf("fake-access-token-%d", len(fake.tokens)),
+		ExpiresIn:   tokenReq.ExpiresIn,
+		Scope:       tokenReq.Scope,
+		TokenType:   "Bearer",
+	}
+	if reference {
+		resp.ReferenceToken = fmt.Sprintf("fake-reference-token-%d", len(fake.tokens))
+	}
+	fakeJSON(w, http.StatusOK, resp)
+}
+
+// serveProjectGroup serves the project membership of groups at <project key>/groups/<group name>
+func (fake *fakeArtifactory) serveProjectGroup(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[1] != "groups" {
+		fakeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	projectKey, group := parts[0], parts[2]
+	members, ok := fake.projects[projectKey]
+	if !ok {
+		fakeError(w, http.StatusNotFound, fmt.Sprintf("Project '%s' not found", projectKey))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if _, ok := fake.groups[group]; !ok {
+			fakeError(w, http.StatusBadRequest, fmt.Sprintf("Group '%s' does not exist", group))
+			return
+		}
+		var member projectGroup
+		if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+			fakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		members[group] = member.Roles
+		fakeJSON(w, http.StatusOK, member)
+	case http.MethodDelete:
+		if _, ok := members[group]; !ok {
+			fakeError(w, http.StatusNotFound, fmt.Sprintf("Group '%s' is not a member of project '%s'", group, projectKey))
+			return
+		}
+		delete(members, group)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	}
+}
+
+// groupsExist answers a bad request unless all the comma separated groups exist
+func (fake *fakeArtifactory) groupsExist(w http.ResponseWriter, groups string) bool {
+	for _, group := range strings.Split(groups, ",") {
+		if _, ok := fake.groups[group]; !ok {
+			fakeError(w, http.StatusBadRequest, fmt.Sprintf("Group '%s' does not exist", group))
+			return false
+		}
+	}
+	return true
+}
+
+func fakeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func fakeError(w http.ResponseWriter, status int, message string) {
+	fakeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]interface{}{{"status": status, "message": message}},
+	})
+}
+
+// newFakeArtifactoryEnv returns a backend configured against a new fake Artifactory
+func newFakeArtifactoryEnv(t *testing.T) (*logical.Request, *ArtifactoryBackend, *fakeArtifactory) {
+	t.Helper()
+
+	fake := newFakeArtifactory(t)
+	b, storage := getTestBackend(t, false)
+	backend := b.(*ArtifactoryBackend)
+	req := &logical.Request{Storage: storage}
+	testConfigUpdate(t, backend, storage, fake.config())
+	return req, backend, fake
+}
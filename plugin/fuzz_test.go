@@ -0,0 +1,175 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seeds of the fuzz targets, they run as regular tests without -fuzz
+var permissionTargetSeeds = []string{
+	`[{"repo": {"repositories": ["repo"], "operations": ["read"]}}]`,
+	`[{"repo": {"include_patterns": ["/mytest/**"], "exclude_patterns": [""], "repositories": ["ANY REMOTE"], "operations": ["read", "write", "annotate"]}}]`,
+	`[{"build": {"repositories": ["artifactory-build-info"], "operations": ["manage"]}}]`,
+	`[{"repo": {"include_patterns": ["users/{{identity.entity.name}}/**"], "repositories": ["repo"], "operations": ["read"]}}]`,
+	`[{"repo": {"include_patterns": ["{{identity.entity.metadata"], "repositories": ["repo"], "operations": ["read"]}}]`,
+	`[{"repo": {"repositories": ["repo"], "operations": ["fly"]}}]`,
+	`[{"repo": {"repositories": [], "operations": ["read"]}, "build": {}}]`,
+	`[{}]`,
+	`[]`,
+	`{"repo": {}}`,
+	`null`,
+	`[null]`,
+	``,
+}
+
+var roleNameSeeds = []string{
+	"",
+	"role",
+	"rolename-long-but-less-than-max",
+	"rolename-too-long-to-fit-into-artifactory-token-username",
+	"role.0123456789abcdef0123456789abcdef",
+	strings.Repeat("x", 200),
+	"rôle-ünicode-names-are-not-valid-but-must-not-break-the-length",
+}
+
+func FuzzParsePermissionTargets(f *testing.F) {
+	for _, seed := range permissionTargetSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		pts, err := parsePermissionTargets(raw)
+		if err != nil {
+			require.Nil(t, pts, "permission targets returned with an error")
+			return
+		}
+		require.NotEmpty(t, pts)
+
+		// the stored form of valid permission targets parses back to the same permission targets
+		stored, err := json.Marshal(pts)
+		require.NoError(t, err)
+		reparsed, err := parsePermissionTargets(string(stored))
+		require.NoError(t, err, "stored permission targets %s", stored)
+		assert.Equal(t, pts, reparsed)
+
+		role := &RoleStorageEntry{Name: "fuzz", RoleID: roleID("fuzz")}
+		for i := range pts {
+			pt := pts[i]
+			require.NoError(t, pt.assertValid())
+
+			ptName := permissionTargetName(role.Name, i)
+			params := &services.PermissionTargetParams{}
+			convertPermissionTarget(&pt, params, groupName(role), ptName)
+			assert.Equal(t, ptName, params.Name)
+			assertConvertedSection(t, pt.Repo, params.Repo, groupName(role))
+			assertConvertedSection(t, pt.Build, params.Build, groupName(role))
+		}
+	})
+}
+
+// assertConvertedSection checks the Artifactory section keeps the permission and only grants it to the group
+func assertConvertedSection(t *testing.T, p *Permission, section *services.PermissionTargetSection, group string) {
+	t.Helper()
+
+	if p == nil {
+		assert.Nil(t, section)
+		return
+	}
+	require.NotNil(t, section)
+	assert.Equal(t, p.Repositories, section.Repositories)
+	assert.Equal(t, p.IncludePatterns, section.IncludePatterns)
+	assert.Equal(t, p.ExcludePatterns, section.ExcludePatterns)
+	require.NotNil(t, section.Actions)
+	assert.Equal(t, map[string][]string{group: p.Operations}, section.Actions.Groups)
+	assert.Empty(t, section.Actions.Users)
+}
+
+func FuzzTokenUsername(f *testing.F) {
+	for _, seed := range roleNameSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, roleName string) {
+		username := tokenUsername(roleName)
+		checkTokenUsernameLength(t, username)
+		assert.Equal(t, username, tokenUsername(roleName), "token username is not deterministic")
+		assert.True(t, strings.HasPrefix(username, "auto-"+pluginPrefix+"."), "token username %q has no prefix", username)
+
+		full := "auto-" + pluginPrefix + "." + roleName
+		if len(full) <= tokenUsernameMaxLen {
+			assert.Equal(t, full, username, "a token username which fits is truncated")
+		} else {
+			assert.Len(t, username, tokenUsernameMaxLen)
+		}
+	})
+}
+
+func FuzzPermissionTargetName(f *testing.F) {
+	for _, seed := range roleNameSeeds {
+		f.Add(seed, 0, seed+".suffix", 1)
+		f.Add(seed, 12, "2."+seed, 1)
+	}
+
+	f.Fuzz(func(t *testing.T, roleA string, indexA int, roleB string, indexB int) {
+		nameA, nameB := permissionTargetName(roleA, indexA), permissionTargetName(roleB, indexB)
+		assert.True(t, strings.HasPrefix(nameA, pluginPrefix+"."), "permission target name %q has no prefix", nameA)
+		if roleA == roleB && indexA == indexB {
+			assert.Equal(t, nameA, nameB, "permission target name is not deterministic")
+		} else {
+			assert.NotEqual(t, nameA, nameB, "roles %q/%d and %q/%d share a permission target name", roleA, indexA, roleB, indexB)
+		}
+	})
+}
+
+func TestNamingProperties(t *testing.T) {
+	t.Parallel()
+
+	t.Run("token_username_length", func(t *testing.T) {
+		t.Parallel()
+		fits := func(roleName string) bool {
+			return len(tokenUsername(roleName)) <= tokenUsernameMaxLen
+		}
+		require.NoError(t, quick.Check(fits, nil))
+	})
+
+	t.Run("role_permission_target_names_unique", func(t *testing.T) {
+		t.Parallel()
+		unique := func(roleName string, count uint8) bool {
+			names := map[string]bool{}
+			for i := 0; i < int(count); i++ {
+				names[permissionTargetName(roleName, i)] = true
+			}
+			return len(names) == int(count)
+		}
+		require.NoError(t, quick.Check(unique, nil))
+	})
+
+	t.Run("role_id_length", func(t *testing.T) {
+		t.Parallel()
+		fits := func(roleName string) bool {
+			id := roleID(roleName)
+			return len(id) == roleIDHashLen && len(groupName(&RoleStorageEntry{RoleID: id})) <= maxArtifactoryNameLen
+		}
+		require.NoError(t, quick.Check(fits, nil))
+	})
+}
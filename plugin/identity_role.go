@@ -0,0 +1,226 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	identityRolesPrefix = "identity-roles"
+	identityRoleHashLen = 12
+)
+
+// IdentityRoleEntry is a role whose identity templates were resolved for the callers
+// with the same identity values. It owns its own group and permission targets.
+type IdentityRoleEntry struct {
+	// The role the identity templates come from
+	BaseRole string `json:"base_role" structs:"base_role" mapstructure:"base_role"`
+
+	// The resolved role, named after the base role and a hash of its permission targets
+	Role RoleStorageEntry `json:"role" structs:"role" mapstructure:"role"`
+
+	// When a token was last issued from the resolved role, updated at most once per identityRoleTouchInterval
+	LastUsed time.Time `json:"last_used" structs:"last_used" mapstructure:"last_used"`
+}
+
+// identity roles record their use at most once per interval, so most token requests don't write
+// storage, which performance standbys can't
+const identityRoleTouchInterval = time.Minute
+
+// idle reports whether no token issued from the resolved role can still be valid
+func (entry IdentityRoleEntry) idle(now time.Time) bool {
+	return now.Sub(entry.LastUsed) > entry.Role.MaxTTL+identityRoleTouchInterval
+}
+
+func hasIdentityTemplates(pts []PermissionTarget) bool {
+	for _, pt := range pts {
+		if pt.hasIdentityTemplates() {
+			return true
+		}
+	}
+	return false
+}
+
+func identityRoleKey(baseRole, hash string) string {
+	return fmt.Sprintf("%s/%s/%s", identityRolesPrefix, baseRole, hash)
+}
+
+func getIdentityRoleEntry(ctx context.Context, storage logical.Storage, key string) (*IdentityRoleEntry, error) {
+	var result IdentityRoleEntry
+	if entry, err := storage.Get(ctx, key); err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, nil
+	} else if _, _, err := decodeUpgradedJSON(entry, upgradeIdentityRoleRecord, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (entry IdentityRoleEntry) save(ctx context.Context, storage logical.Storage, key string) error {
+	entry.Role.SchemaVersion = roleSchemaVersion
+	storageEntry, err := logical.StorageEntryJSON(key, entry)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, storageEntry)
+}
+
+// identityRole resolves the identity templates of the role for the entity of the request,
+// creating the group and permission targets of the resolved role the first time they are needed.
+func (backend *ArtifactoryBackend) identityRole(ctx context.Context, req *logical.Request, role *RoleStorageEntry) (*RoleStorageEntry, error) {
+	if req.EntityID == "" {
+		return nil, fmt.Errorf("role '%s' uses identity templates and requires a token with an identity entity", role.Name)
+	}
+
+	pts := make([]PermissionTarget, len(role.PermissionTargets))
+	for idx, pt := range role.PermissionTargets {
+		resolved, err := pt.resolveIdentityTemplates(req.EntityID, backend.System())
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve identity templates of role '%s' - %w", role.Name, err)
+		}
+		pts[idx] = resolved
+	}
+	rawPts, err := json.Marshal(pts)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(rawPts))[:identityRoleHashLen]
+	key := identityRoleKey(role.Name, hash)
+	name := fmt.Sprintf("%s.%s", role.Name, hash)
+
+	lock := locksutil.LockForKey(backend.identityLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := getIdentityRoleEntry(ctx, req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if entry != nil && entry.Role.TokenTTL == role.TokenTTL && entry.Role.MaxTTL == role.MaxTTL &&
+		now.Sub(entry.LastUsed) < identityRoleTouchInterval {
+		return &entry.Role, nil
+	}
+	if entry == nil {
+		entry = &IdentityRoleEntry{
+			BaseRole: role.Name,
+			Role: RoleStorageEntry{
+				Name:                 name,
+				RoleID:               roleID(name),
+				Description:          role.Description,
+				Mode:                 role.Mode,
+				Naming:               role.Naming,
+				RawPermissionTargets: string(rawPts),
+				PermissionTargets:    pts,
+			},
+		}
+		// a failed sync is retried by the next token request as nothing is stored
+		if err := backend.syncIdentityRole(ctx, req, &entry.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	entry.Role.TokenTTL = role.TokenTTL
+	entry.Role.MaxTTL = role.MaxTTL
+	entry.LastUsed = now
+	if err := entry.save(ctx, req.Storage, key); err != nil {
+		return nil, err
+	}
+
+	return &entry.Role, nil
+}
+
+func (backend *ArtifactoryBackend) syncIdentityRole(ctx context.Context, req *logical.Request, role *RoleStorageEntry) (err error) {
+	backend.Logger().Debug("creating group and permission targets for identity role", "name", role.Name)
+	defer func() { emitRoleSync(role.Name, err) }()
+
+	ac, err := backend.getClient(ctx, req.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+
+	return createRoleObjects(ctx, ac, role)
+}
+
+// collectIdentityRoles removes the identity roles of baseRole, or of every role when baseRole
+// is empty. Unless force is set, only the idle identity roles are removed.
+func (backend *ArtifactoryBackend) collectIdentityRoles(ctx context.Context, req *logical.Request, baseRole string, force bool) error {
+	baseRoles := []string{baseRole}
+	if baseRole == "" {
+		var err error
+		if baseRoles, err = req.Storage.List(ctx, identityRolesPrefix+"/"); err != nil {
+			return err
+		}
+	}
+
+	var merr *multierror.Error
+	now := time.Now()
+	for _, base := range baseRoles {
+		base = strings.TrimSuffix(base, "/")
+		hashes, err := req.Storage.List(ctx, fmt.Sprintf("%s/%s/", identityRolesPrefix, base))
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+
+		for _, hash := range hashes {
+			if err := backend.collectIdentityRole(ctx, req, identityRoleKey(base, hash), now, force); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+func (backend *ArtifactoryBackend) collectIdentityRole(ctx context.Context, req *logical.Request, key string, now time.Time, force bool) error {
+	entry, err := getIdentityRoleEntry(ctx, req.Storage, key)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	lock := locksutil.LockForKey(backend.identityLocks, entry.Role.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// re-read under the lock as a token request may have used it meanwhile
+	entry, err = getIdentityRoleEntry(ctx, req.Storage, key)
+	if err != nil || entry == nil {
+		return err
+	}
+	if !force && !entry.idle(now) {
+		return nil
+	}
+
+	backend.Logger().Info("removing identity role", "name", entry.Role.Name, "base_role", entry.BaseRole, "last_used", entry.LastUsed)
+	if err := backend.tryDeleteRoleResources(ctx, req, &entry.Role, entry.Role.PermissionTargets, 0, true); err != nil {
+		// kept in storage so the next run tries again
+		return err
+	}
+	return req.Storage.Delete(ctx, key)
+}
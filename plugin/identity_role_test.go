@@ -0,0 +1,287 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIdentityPt = `[{"repo": {"include_patterns": ["/{{identity.entity.metadata.team}}/**"], "repositories": ["libs-local"], "operations": ["write"]}}]`
+
+// newIdentityMockEnv returns a mock env whose system view can be pointed at a test entity
+func newIdentityMockEnv(t *testing.T) (*logical.Request, *ArtifactoryBackend, *logical.StaticSystemView, *recordingClient) {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	sysView := config.System.(*logical.StaticSystemView)
+
+	b, err := Factory(context.Background(), config)
+	require.NoError(t, err, "unable to create backend")
+	backend := b.(*ArtifactoryBackend)
+	client := newRecordingClient()
+	backend.client = client
+
+	req := &logical.Request{Storage: config.StorageView}
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	})
+	return req, backend, sysView, client
+}
+
+func TestIdentityRole(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, sysView, client := newIdentityMockEnv(t)
+	mustRoleCreate(req, backend, t, "team-publish", map[string]interface{}{
+		"permission_targets": testIdentityPt,
+	})
+
+	groups, pts := client.counts()
+	assert.Equal(t, 0, groups, "templated role must not create a group")
+	assert.Equal(t, 0, pts, "templated role must not create permission targets")
+
+	issue := func(entityID, team string) *logical.Response {
+		sysView.EntityVal = &logical.Entity{ID: entityID, Metadata: map[string]string{"team": team}}
+		req.EntityID = entityID
+		defer func() { req.EntityID = "" }()
+
+		resp, err := testIssueToken(req, backend, t, "team-publish", map[string]interface{}{"role_name": "team-publish"})
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("resolved_per_identity", func(t *testing.T) {
+		resp := issue("entity-1", "team-x")
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+		// same identity values share the resolved role
+		resp = issue("entity-2", "team-x")
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		groups, pts := client.counts()
+		assert.Equal(t, 1, groups)
+		assert.Equal(t, 1, pts)
+
+		resp = issue("entity-3", "team-y")
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		groups, pts = client.counts()
+		assert.Equal(t, 2, groups)
+		assert.Equal(t, 2, pts)
+
+		patterns := []string{}
+		for _, pt := range client.pts {
+			patterns = append(patterns, pt.Repo.IncludePatterns...)
+		}
+		assert.ElementsMatch(t, []string{"/team-x/**", "/team-y/**"}, patterns)
+	})
+
+	t.Run("unresolvable", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, "team-publish", map[string]interface{}{"role_name": "team-publish"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "requires a token with an identity entity")
+
+		sysView.EntityVal = &logical.Entity{ID: "entity-4"}
+		req.EntityID = "entity-4"
+		resp, err = testIssueToken(req, backend, t, "team-publish", map[string]interface{}{"role_name": "team-publish"})
+		req.EntityID = ""
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "unable to resolve identity templates of role 'team-publish'")
+	})
+
+	t.Run("collect_idle", func(t *testing.T) {
+		keys, err := req.Storage.List(ctx, identityRolesPrefix+"/team-publish/")
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+
+		// age one of the identity roles past the role max ttl
+		key := identityRoleKey("team-publish", keys[0])
+		entry, err := getIdentityRoleEntry(ctx, req.Storage, key)
+		require.NoError(t, err)
+		entry.LastUsed = time.Now().Add(-entry.Role.MaxTTL - 2*identityRoleTouchInterval)
+		require.NoError(t, entry.save(ctx, req.Storage, key))
+
+		require.NoError(t, backend.periodic(ctx, req))
+
+		keys, err = req.Storage.List(ctx, identityRolesPrefix+"/team-publish/")
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+		groups, pts := client.counts()
+		assert.Equal(t, 1, groups)
+		assert.Equal(t, 1, pts)
+	})
+
+	t.Run("role_delete", func(t *testing.T) {
+		mustRoleDelete(req, backend, t, "team-publish")
+
+		keys, err := req.Storage.List(ctx, identityRolesPrefix+"/")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+		groups, pts := client.counts()
+		assert.Equal(t, 0, groups)
+		assert.Equal(t, 0, pts)
+	})
+}
+
+func TestIdentityRoleStandby(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, sysView, _ := newIdentityMockEnv(t)
+	mustRoleCreate(req, backend, t, "team-publish", map[string]interface{}{
+		"permission_targets": testIdentityPt,
+	})
+	storage := req.Storage
+
+	issue := func(entityID, team string) (*logical.Response, error) {
+		sysView.EntityVal = &logical.Entity{ID: entityID, Metadata: map[string]string{"team": team}}
+		req.EntityID = entityID
+		defer func() { req.EntityID = "" }()
+		return testIssueToken(req, backend, t, "team-publish", map[string]interface{}{"role_name": "team-publish"})
+	}
+
+	resp, err := issue("entity-1", "team-x")
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+	// performance standbys can't write, recently used identity roles are issued from without writing
+	req.Storage = &failingStorage{Storage: storage, prefix: identityRolesPrefix + "/", err: logical.ErrReadOnly}
+	defer func() { req.Storage = storage }()
+	resp, err = issue("entity-2", "team-x")
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+	// writes are returned as errors, so the request is forwarded to the active node
+	_, err = issue("entity-3", "team-y")
+	assert.ErrorIs(t, err, logical.ErrReadOnly)
+
+	keys, err := storage.List(ctx, identityRolesPrefix+"/team-publish/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	key := identityRoleKey("team-publish", keys[0])
+	entry, err := getIdentityRoleEntry(ctx, storage, key)
+	require.NoError(t, err)
+	entry.LastUsed = time.Now().Add(-2 * identityRoleTouchInterval)
+	require.NoError(t, entry.save(ctx, storage, key))
+
+	_, err = issue("entity-1", "team-x")
+	assert.ErrorIs(t, err, logical.ErrReadOnly)
+
+	// the active node records the use
+	req.Storage = storage
+	resp, err = issue("entity-1", "team-x")
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	entry, err = getIdentityRoleEntry(ctx, storage, key)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), entry.LastUsed, identityRoleTouchInterval)
+}
+
+func TestIdentityTemplateValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		pt   PermissionTarget
+		err  string
+	}{
+		{
+			name: "include_pattern",
+			pt:   PermissionTarget{Repo: &Permission{IncludePatterns: []string{"/{{identity.entity.name}}/**"}, Repositories: []string{"libs-local"}, Operations: []string{"read"}}},
+		},
+		{
+			name: "exclude_pattern",
+			pt:   PermissionTarget{Build: &Permission{ExcludePatterns: []string{"{{identity.entity.id}}/**"}, Repositories: []string{"artifactory-build-info"}, Operations: []string{"read"}}},
+		},
+		{
+			name: "invalid_template",
+			pt:   PermissionTarget{Repo: &Permission{IncludePatterns: []string{"/{{identity.entity.name/**"}, Repositories: []string{"libs-local"}, Operations: []string{"read"}}},
+			err:  "unbalanced templating characters",
+		},
+		{
+			name: "templated_repository",
+			pt:   PermissionTarget{Repo: &Permission{Repositories: []string{"{{identity.entity.name}}-local"}, Operations: []string{"read"}}},
+			err:  "identity templates are only supported in 'repo' patterns",
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := test.pt.assertValid()
+			if test.err != "" {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, test.pt.hasIdentityTemplates())
+		})
+	}
+}
+
+func TestResolveIdentityTemplates(t *testing.T) {
+	t.Parallel()
+
+	pt := PermissionTarget{Repo: &Permission{
+		IncludePatterns: []string{"team/{{identity.entity.metadata.team}}/**"},
+		ExcludePatterns: []string{"team/{{identity.entity.metadata.team}}/secret/**"},
+		Repositories:    []string{"libs-local"},
+		Operations:      []string{"read"},
+	}}
+
+	tests := []struct {
+		name string
+		team string
+		err  string
+	}{
+		{name: "folder", team: "team-x"},
+		{name: "dots_in_name", team: "team.x"},
+		{name: "empty", team: "", err: "resolved to an empty value"},
+		{name: "double_star", team: "**", err: "resolved to a value with '*', '?', '/' or '..'"},
+		{name: "star", team: "*", err: "resolved to a value with '*', '?', '/' or '..'"},
+		{name: "question_mark", team: "team-?", err: "resolved to a value with '*', '?', '/' or '..'"},
+		{name: "slash", team: "team-x/team-y", err: "resolved to a value with '*', '?', '/' or '..'"},
+		{name: "parent_folder", team: "x/../y", err: "resolved to a value with '*', '?', '/' or '..'"},
+		{name: "dot_dot", team: "..", err: "resolved to a value with '*', '?', '/' or '..'"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			sysView := &logical.StaticSystemView{EntityVal: &logical.Entity{ID: "entity-1", Metadata: map[string]string{"team": test.team}}}
+			resolved, err := pt.resolveIdentityTemplates("entity-1", sysView)
+			if test.err != "" {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []string{"team/" + test.team + "/**"}, resolved.Repo.IncludePatterns)
+			assert.Equal(t, []string{"team/" + test.team + "/secret/**"}, resolved.Repo.ExcludePatterns)
+		})
+	}
+}
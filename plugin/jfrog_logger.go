@@ -0,0 +1,176 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+)
+
+const (
+	defaultLogLevel = "warn"
+	redactedValue   = "[REDACTED]"
+)
+
+var (
+	// jfrog-client-go logs through a package level logger, so a single adapter is installed once
+	// per process. Its sink is swapped by the backend whenever it builds a client.
+	jfrogLog     = newJfrogLogger()
+	jfrogLogOnce sync.Once
+
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`),
+		regexp.MustCompile(`(?i)("(?:access_token|refresh_token|reference_token|password|apiKey)"\s*:\s*")[^"]*`),
+		regexp.MustCompile(`(?i)((?:access_token|refresh_token|password|api_?key)=)[^&\s]+`),
+		regexp.MustCompile(`(://[^:/@\s]+:)[^@\s]+(@)`),
+	}
+)
+
+var _ log.Log = &jfrogLogger{}
+
+// jfrogSink is where the jfrog-client-go logs go, at which level and with which secrets redacted
+type jfrogSink struct {
+	logger  hclog.Logger
+	level   hclog.Level
+	secrets []string
+}
+
+func newJfrogSink(logger hclog.Logger, level hclog.Level, secrets ...string) *jfrogSink {
+	sink := &jfrogSink{
+		logger: logger.Named("jfrog"),
+		level:  level,
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			sink.secrets = append(sink.secrets, secret)
+		}
+	}
+	return sink
+}
+
+// configSink returns the sink of the clients of config, logging at its log level and redacting its credentials
+func configSink(logger hclog.Logger, config *ConfigStorageEntry) *jfrogSink {
+	if config == nil {
+		return nil
+	}
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		level = hclog.Warn
+	}
+	return newJfrogSink(logger, level, config.BearerToken, config.ApiKey, config.Password)
+}
+
+// jfrogLogger adapts the jfrog-client-go logger to the sink of the backend. An external plugin
+// process serves a single mount, so there is one sink at a time. Messages logged without a sink
+// go to the fallback sink.
+type jfrogLogger struct {
+	mu       sync.RWMutex
+	fallback *jfrogSink
+	sink     *jfrogSink
+}
+
+func newJfrogLogger() *jfrogLogger {
+	return &jfrogLogger{
+		fallback: newJfrogSink(hclog.Default(), hclog.Warn),
+	}
+}
+
+// installJfrogLogger replaces the jfrog-client-go logger with the shared adapter
+func installJfrogLogger() {
+	jfrogLogOnce.Do(func() {
+		log.SetLogger(jfrogLog)
+	})
+}
+
+// setSink sends the messages to sink
+func (l *jfrogLogger) setSink(sink *jfrogSink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sink = sink
+}
+
+// releaseSink sends the messages to the fallback sink, unless another sink replaced sink meanwhile
+func (l *jfrogLogger) releaseSink(sink *jfrogSink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sink == sink {
+		l.sink = nil
+	}
+}
+
+func (l *jfrogLogger) Debug(a ...interface{}) {
+	l.log(hclog.Debug, a...)
+}
+
+func (l *jfrogLogger) Info(a ...interface{}) {
+	l.log(hclog.Info, a...)
+}
+
+func (l *jfrogLogger) Warn(a ...interface{}) {
+	l.log(hclog.Warn, a...)
+}
+
+func (l *jfrogLogger) Error(a ...interface{}) {
+	l.log(hclog.Error, a...)
+}
+
+func (l *jfrogLogger) Output(a ...interface{}) {
+	l.log(hclog.Info, a...)
+}
+
+func (l *jfrogLogger) log(level hclog.Level, a ...interface{}) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	sink := l.sink
+	if sink == nil {
+		sink = l.fallback
+	}
+	if sink.level == hclog.Off || level < sink.level {
+		return
+	}
+	msg := strings.TrimSpace(fmt.Sprintln(a...))
+	sink.logger.Log(level, sink.redact(msg))
+}
+
+func (sink *jfrogSink) redact(msg string) string {
+	for _, secret := range sink.secrets {
+		msg = strings.ReplaceAll(msg, secret, redactedValue)
+	}
+	for _, re := range secretPatterns {
+		msg = re.ReplaceAllString(msg, "${1}"+redactedValue+"${2}")
+	}
+	return msg
+}
+
+// parseLogLevel converts a configured log level into an hclog level
+func parseLogLevel(level string) (hclog.Level, error) {
+	if level == "" {
+		level = defaultLogLevel
+	}
+	if strings.EqualFold(strings.TrimSpace(level), "off") {
+		return hclog.Off, nil
+	}
+	l := hclog.LevelFromString(level)
+	if l == hclog.NoLevel {
+		return hclog.NoLevel, fmt.Errorf("invalid log level '%s'", level)
+	}
+	return l, nil
+}
@@ -0,0 +1,154 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// Metrics are emitted through the go-metrics global sink of the process running the backend.
+// Vault's telemetry stanza doesn't reach a plugin running in its own process, see ConfigureMetrics.
+var (
+	metricTokenIssued   = []string{"artifactory", "token", "issued"}
+	metricTokenFailed   = []string{"artifactory", "token", "failed"}
+	metricRoleSync      = []string{"artifactory", "role", "sync"}
+	metricClientCall    = []string{"artifactory", "client", "call"}
+	metricClientLatency = []string{"artifactory", "client", "latency"}
+)
+
+// reasons for a token issuance failure
+const (
+	tokenFailureRoleNotFound = "role_not_found"
+	tokenFailureTTLExceeded  = "ttl_exceeded"
+	tokenFailureCircuitOpen  = "circuit_open"
+	tokenFailureArtifactory  = "artifactory_error"
+	tokenFailureIdentity     = "identity_error"
+)
+
+// ConfigureMetrics sends the metrics of the plugin process to the sink at sinkURL, e.g.
+// statsd://127.0.0.1:8125 or statsite://127.0.0.1:8125. Metrics are discarded when it's empty.
+func ConfigureMetrics(sinkURL string) error {
+	if sinkURL == "" {
+		return nil
+	}
+	sink, err := metrics.NewMetricSinkFromURL(sinkURL)
+	if err != nil {
+		return fmt.Errorf("invalid metrics sink url '%s' - %s", sinkURL, err.Error())
+	}
+
+	// named like the metrics of Vault, without the runtime metrics Vault already reports
+	conf := metrics.DefaultConfig("vault")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		return fmt.Errorf("failed to configure metrics - %s", err.Error())
+	}
+	return nil
+}
+
+func emitTokenIssued(roleName string) {
+	metrics.IncrCounterWithLabels(metricTokenIssued, 1, []metrics.Label{
+		{Name: "role", Value: roleName},
+	})
+}
+
+func emitTokenFailed(roleName, reason string) {
+	metrics.IncrCounterWithLabels(metricTokenFailed, 1, []metrics.Label{
+		{Name: "role", Value: roleName},
+		{Name: "reason", Value: reason},
+	})
+}
+
+func emitRoleSync(roleName string, err error) {
+	metrics.IncrCounterWithLabels(metricRoleSync, 1, []metrics.Label{
+		{Name: "role", Value: roleName},
+		{Name: "success", Value: strconv.FormatBool(err == nil)},
+	})
+}
+
+// instrumentedClient records a call counter and latency for every Artifactory call
+type instrumentedClient struct {
+	Client
+}
+
+var _ Client = &instrumentedClient{}
+
+func instrument(client Client) Client {
+	return &instrumentedClient{Client: client}
+}
+
+func (c *instrumentedClient) observe(method string, start time.Time, err error) {
+	labels := []metrics.Label{
+		{Name: "method", Value: method},
+	}
+	metrics.MeasureSinceWithLabels(metricClientLatency, start, labels)
+	metrics.IncrCounterWithLabels(metricClientCall, 1, append(labels,
+		metrics.Label{Name: "success", Value: strconv.FormatBool(err == nil)}))
+}
+
+func (c *instrumentedClient) CreateOrReplaceGroup(ctx context.Context, role *RoleStorageEntry) (err error) {
+	defer func(start time.Time) { c.observe("CreateOrReplaceGroup", start, err) }(time.Now())
+	return c.Client.CreateOrReplaceGroup(ctx, role)
+}
+
+func (c *instrumentedClient) DeleteGroup(ctx context.Context, role *RoleStorageEntry) (err error) {
+	defer func(start time.Time) { c.observe("DeleteGroup", start, err) }(time.Now())
+	return c.Client.DeleteGroup(ctx, role)
+}
+
+func (c *instrumentedClient) RemoveGroupFromProject(ctx context.Context, role *RoleStorageEntry, projectKey string) (err error) {
+	defer func(start time.Time) { c.observe("RemoveGroupFromProject", start, err) }(time.Now())
+	return c.Client.RemoveGroupFromProject(ctx, role, projectKey)
+}
+
+func (c *instrumentedClient) CreateOrReplaceServiceUser(ctx context.Context, role *RoleStorageEntry) (err error) {
+	defer func(start time.Time) { c.observe("CreateOrReplaceServiceUser", start, err) }(time.Now())
+	return c.Client.CreateOrReplaceServiceUser(ctx, role)
+}
+
+func (c *instrumentedClient) DeleteServiceUser(ctx context.Context, role *RoleStorageEntry) (err error) {
+	defer func(start time.Time) { c.observe("DeleteServiceUser", start, err) }(time.Now())
+	return c.Client.DeleteServiceUser(ctx, role)
+}
+
+func (c *instrumentedClient) CreateOrUpdatePermissionTarget(ctx context.Context, role *RoleStorageEntry, pt *PermissionTarget, ptName string) (err error) {
+	defer func(start time.Time) { c.observe("CreateOrUpdatePermissionTarget", start, err) }(time.Now())
+	return c.Client.CreateOrUpdatePermissionTarget(ctx, role, pt, ptName)
+}
+
+func (c *instrumentedClient) DeletePermissionTarget(ctx context.Context, ptName string) (err error) {
+	defer func(start time.Time) { c.observe("DeletePermissionTarget", start, err) }(time.Now())
+	return c.Client.DeletePermissionTarget(ctx, ptName)
+}
+
+func (c *instrumentedClient) CreateToken(ctx context.Context, tokenReq TokenCreateEntry, role *RoleStorageEntry) (token Token, err error) {
+	defer func(start time.Time) { c.observe("CreateToken", start, err) }(time.Now())
+	return c.Client.CreateToken(ctx, tokenReq, role)
+}
+
+func (c *instrumentedClient) SystemInfo(ctx context.Context) (info *SystemInfo, err error) {
+	defer func(start time.Time) { c.observe("SystemInfo", start, err) }(time.Now())
+	return c.Client.SystemInfo(ctx)
+}
+
+func (c *instrumentedClient) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { c.observe("Ping", start, err) }(time.Now())
+	return c.Client.Ping(ctx)
+}
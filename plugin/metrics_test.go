@@ -0,0 +1,131 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSink     *metrics.InmemSink
+	testSinkOnce sync.Once
+)
+
+// getTestMetricsSink installs a process wide in-memory metrics sink shared by all tests.
+// Tests must use unique role names to tell their metrics apart.
+func getTestMetricsSink(t *testing.T) *metrics.InmemSink {
+	t.Helper()
+	testSinkOnce.Do(func() {
+		testSink = metrics.NewInmemSink(time.Hour, time.Hour)
+		conf := metrics.DefaultConfig("vault")
+		conf.EnableHostname = false
+		conf.EnableRuntimeMetrics = false
+		_, err := metrics.NewGlobal(conf, testSink)
+		require.NoError(t, err)
+	})
+	return testSink
+}
+
+func counterValue(sink *metrics.InmemSink, key string) int {
+	for _, interval := range sink.Data() {
+		interval.RLock()
+		counter, ok := interval.Counters[key]
+		interval.RUnlock()
+		if ok {
+			return counter.Count
+		}
+	}
+	return 0
+}
+
+func sampleCount(sink *metrics.InmemSink, key string) int {
+	for _, interval := range sink.Data() {
+		interval.RLock()
+		sample, ok := interval.Samples[key]
+		interval.RUnlock()
+		if ok {
+			return sample.Count
+		}
+	}
+	return 0
+}
+
+func TestTokenMetrics(t *testing.T) {
+	t.Parallel()
+	sink := getTestMetricsSink(t)
+
+	req, backend := newArtMockEnv(t)
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	}
+	testConfigUpdate(t, backend, req.Storage, conf)
+
+	roleName := "test_metrics_role"
+	mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"name":               roleName,
+		"permission_targets": `[{"repo": {"repositories": ["ANY"], "operations": ["read"]}}]`,
+	})
+	assert.Equal(t, 1, counterValue(sink, "vault.artifactory.role.sync;role=test_metrics_role;success=true"))
+
+	resp, err := testIssueToken(req, backend, t, roleName, map[string]interface{}{"role_name": roleName})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	resp, err = testIssueToken(req, backend, t, roleName, map[string]interface{}{"role_name": roleName, "ttl": "7200s"})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	resp, err = testIssueToken(req, backend, t, "test_metrics_missing_role", map[string]interface{}{"role_name": "test_metrics_missing_role"})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	assert.Equal(t, 1, counterValue(sink, "vault.artifactory.token.issued;role=test_metrics_role"))
+	assert.Equal(t, 1, counterValue(sink, "vault.artifactory.token.failed;role=test_metrics_role;reason=ttl_exceeded"))
+	assert.Equal(t, 1, counterValue(sink, "vault.artifactory.token.failed;role=test_metrics_missing_role;reason=role_not_found"))
+}
+
+func TestInstrumentedClient(t *testing.T) {
+	t.Parallel()
+	sink := getTestMetricsSink(t)
+
+	c := instrument(newRecordingClient())
+	ctx := context.Background()
+
+	require.NoError(t, c.DeletePermissionTarget(ctx, "test_instrumented_pt"))
+	require.NoError(t, c.DeletePermissionTarget(ctx, "test_instrumented_pt"))
+
+	assert.GreaterOrEqual(t, counterValue(sink, "vault.artifactory.client.call;method=DeletePermissionTarget;success=true"), 2)
+	assert.GreaterOrEqual(t, sampleCount(sink, "vault.artifactory.client.latency;method=DeletePermissionTarget"), 2)
+}
+
+func TestConfigureMetrics(t *testing.T) {
+	t.Parallel()
+
+	// the global sink is shared by the tests, only the urls which don't replace it are tested
+	assert.NoError(t, ConfigureMetrics(""))
+
+	err := ConfigureMetrics("prometheus://127.0.0.1:9102")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid metrics sink url 'prometheus://127.0.0.1:9102'")
+}
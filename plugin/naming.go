@@ -0,0 +1,200 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Artifactory limits the names of groups and users to 64 characters, permission target names can be longer
+const maxArtifactoryNameLen = 64
+
+// name template placeholders
+const (
+	namingPrefix   = "prefix"
+	namingRoleName = "role_name"
+	namingRoleID   = "role_id"
+	namingIndex    = "index"
+)
+
+var (
+	namePrefixRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	// what is left of a name template once its placeholders are removed
+	nameTemplateLiteralRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]*$`)
+)
+
+// Naming is how the Artifactory objects of a role are named. Templates use {{prefix}}, {{role_id}},
+// {{role_name}} and {{index}} placeholders. Groups are named after the role id only, as renamed
+// roles keep their group.
+type Naming struct {
+	Prefix                   string `json:"prefix"`
+	GroupTemplate            string `json:"group_template"`
+	PermissionTargetTemplate string `json:"permission_target_template"`
+	TokenUsernameTemplate    string `json:"token_username_template"`
+}
+
+// defaultNaming is the naming of mounts which don't configure one, and of roles created before
+// naming could be configured
+var defaultNaming = Naming{
+	Prefix:                   pluginPrefix,
+	GroupTemplate:            "{{prefix}}.{{role_id}}",
+	PermissionTargetTemplate: "{{prefix}}.pt{{index}}.{{role_name}}",
+	TokenUsernameTemplate:    "auto-{{prefix}}.{{role_name}}",
+}
+
+// withDefaults fills the unset parts of the naming from the default naming
+func (n Naming) withDefaults() Naming {
+	if n.Prefix == "" {
+		n.Prefix = defaultNaming.Prefix
+	}
+	if n.GroupTemplate == "" {
+		n.GroupTemplate = defaultNaming.GroupTemplate
+	}
+	if n.PermissionTargetTemplate == "" {
+		n.PermissionTargetTemplate = defaultNaming.PermissionTargetTemplate
+	}
+	if n.TokenUsernameTemplate == "" {
+		n.TokenUsernameTemplate = defaultNaming.TokenUsernameTemplate
+	}
+	return n
+}
+
+func (n Naming) render(tmpl, roleName, roleID string, index int) string {
+	return templateParamRegex.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		switch templateParamRegex.FindStringSubmatch(placeholder)[1] {
+		case namingPrefix:
+			return n.Prefix
+		case namingRoleName:
+			return roleName
+		case namingRoleID:
+			return roleID
+		case namingIndex:
+			return strconv.Itoa(index)
+		}
+		return placeholder
+	})
+}
+
+func (n Naming) group(roleID string) string {
+	return n.render(n.GroupTemplate, "", roleID, 0)
+}
+
+func (n Naming) serviceUser(roleID string) string {
+	return fmt.Sprintf("%s.user.%s", n.Prefix, roleID)
+}
+
+func (n Naming) permissionTarget(roleName, roleID string, index int) string {
+	return n.render(n.PermissionTargetTemplate, roleName, roleID, index)
+}
+
+// tokenUsername renders the token username, too long ones are truncated and end with a hash of the cut off part
+func (n Naming) tokenUsername(roleName, roleID string) string {
+	fullUsername := n.render(n.TokenUsernameTemplate, roleName, roleID, 0)
+	tokenUser := fullUsername
+	if len(fullUsername) > tokenUsernameMaxLen {
+		truncIndex := tokenUsernameMaxLen - tokenUsernameHashLen
+		h := fmt.Sprintf("%x", sha256.Sum256([]byte(fullUsername[truncIndex:])))
+		tokenUser = fullUsername[:truncIndex] + h[:tokenUsernameHashLen]
+	}
+	return tokenUser
+}
+
+// validate checks the names of any role fit in Artifactory. Group and service user names have a
+// fixed length, token usernames are truncated and permission target names aren't limited.
+func (n Naming) validate() error {
+	var err *multierror.Error
+	if !namePrefixRegex.MatchString(n.Prefix) {
+		err = multierror.Append(err, fmt.Errorf("name prefix '%s' is not allowed", n.Prefix))
+	}
+	// role ids always take roleIDHashLen characters
+	if len(n.serviceUser(strings.Repeat("0", roleIDHashLen))) > maxArtifactoryNameLen {
+		err = multierror.Append(err, fmt.Errorf("name prefix '%s' is too long", n.Prefix))
+	}
+
+	templates := []struct {
+		field    string
+		tmpl     string
+		allowed  []string
+		required [][]string
+		maxLen   int
+	}{
+		{
+			field:    "group_name_template",
+			tmpl:     n.GroupTemplate,
+			allowed:  []string{namingPrefix, namingRoleID},
+			required: [][]string{{namingRoleID}},
+			maxLen:   maxArtifactoryNameLen,
+		},
+		{
+			field:    "permission_target_name_template",
+			tmpl:     n.PermissionTargetTemplate,
+			allowed:  []string{namingPrefix, namingRoleID, namingRoleName, namingIndex},
+			required: [][]string{{namingIndex}, {namingRoleName, namingRoleID}},
+		},
+		{
+			field:    "token_username_template",
+			tmpl:     n.TokenUsernameTemplate,
+			allowed:  []string{namingPrefix, namingRoleID, namingRoleName},
+			required: [][]string{{namingRoleName, namingRoleID}},
+			maxLen:   tokenUsernameMaxLen - tokenUsernameHashLen,
+		},
+	}
+	for _, t := range templates {
+		if tmplErr := validateNameTemplate(t.field, t.tmpl, t.allowed, t.required); tmplErr != nil {
+			err = multierror.Append(err, tmplErr)
+			continue
+		}
+		if name := n.render(t.tmpl, "", strings.Repeat("0", roleIDHashLen), 0); t.maxLen > 0 && len(name) > t.maxLen {
+			err = multierror.Append(err, fmt.Errorf("%s is too long, names are limited to %d characters", t.field, t.maxLen))
+		}
+	}
+	return err.ErrorOrNil()
+}
+
+// validateNameTemplate checks the template only holds allowed placeholders and name characters,
+// and one placeholder of each of the required sets
+func validateNameTemplate(field, tmpl string, allowed []string, required [][]string) error {
+	used := map[string]bool{}
+	for _, m := range templateParamRegex.FindAllStringSubmatch(tmpl, -1) {
+		known := false
+		for _, placeholder := range allowed {
+			known = known || m[1] == placeholder
+		}
+		if !known {
+			return fmt.Errorf("%s can't use {{%s}}", field, m[1])
+		}
+		used[m[1]] = true
+	}
+	if !nameTemplateLiteralRegex.MatchString(templateParamRegex.ReplaceAllString(tmpl, "")) {
+		return fmt.Errorf("%s may only hold letters, digits, '.', '_' and '-' besides placeholders", field)
+	}
+
+	for _, set := range required {
+		found := false
+		for _, placeholder := range set {
+			found = found || used[placeholder]
+		}
+		if !found {
+			return fmt.Errorf("%s needs {{%s}}", field, strings.Join(set, "}} or {{"))
+		}
+	}
+	return nil
+}
@@ -55,6 +55,27 @@ var configSchema = map[string]*framework.FieldSchema{
 		Description: "Artifactory HTTP client timeout at Transport layer. If <=0, will use system default(30).",
 		Default:     30,
 	},
+	"log_level": {
+		Type:        framework.TypeString,
+		Description: "Log level of the Artifactory client. One of off, error, warn, info, debug or trace.",
+		Default:     defaultLogLevel,
+	},
+	"name_prefix": {
+		Type:        framework.TypeString,
+		Description: "Prefix of the Artifactory objects of the roles, the {{prefix}} of the name templates. Defaults to " + pluginPrefix,
+	},
+	"group_name_template": {
+		Type:        framework.TypeString,
+		Description: "Template of the group names of the roles, needs {{role_id}}. Defaults to " + defaultNaming.GroupTemplate,
+	},
+	"permission_target_name_template": {
+		Type:        framework.TypeString,
+		Description: "Template of the permission target names of the roles, needs {{index}} and {{role_name}} or {{role_id}}. Defaults to " + defaultNaming.PermissionTargetTemplate,
+	},
+	"token_username_template": {
+		Type:        framework.TypeString,
+		Description: "Template of the token usernames of the roles, needs {{role_name}} or {{role_id}}. Defaults to " + defaultNaming.TokenUsernameTemplate,
+	},
 }
 
 func (backend *ArtifactoryBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -66,11 +87,19 @@ func (backend *ArtifactoryBackend) pathConfigRead(ctx context.Context, req *logi
 		return nil, nil
 	}
 
+	naming := cfg.naming()
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"base_url":       cfg.BaseURL,
-			"max_ttl":        int64(cfg.MaxTTL / time.Second),
-			"client_timeout": int64(cfg.ClientTimeout / time.Second),
+			"base_url":                        cfg.BaseURL,
+			"auth_method":                     cfg.authMethod(),
+			"credential_fingerprint":          cfg.credentialFingerprint(),
+			"max_ttl":                         int64(cfg.MaxTTL / time.Second),
+			"client_timeout":                  int64(cfg.ClientTimeout / time.Second),
+			"log_level":                       cfg.LogLevel,
+			"name_prefix":                     naming.Prefix,
+			"group_name_template":             naming.GroupTemplate,
+			"permission_target_name_template": naming.PermissionTargetTemplate,
+			"token_username_template":         naming.TokenUsernameTemplate,
 		},
 	}, nil
 }
@@ -119,12 +148,33 @@ func (backend *ArtifactoryBackend) pathConfigWrite(ctx context.Context, req *log
 		cfg.ClientTimeout = time.Duration(configSchema["client_timeout"].Default.(int)) * time.Second
 	}
 
-	entry, err := logical.StorageEntryJSON(configPrefix, cfg)
-	if err != nil {
-		return nil, err
+	if logLevel, ok := data.GetOk("log_level"); ok {
+		cfg.LogLevel = logLevel.(string)
+	} else if cfg.LogLevel == "" {
+		cfg.LogLevel = configSchema["log_level"].Default.(string)
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// changing the naming only applies to new roles, existing roles move with migrate-naming
+	if namePrefix, ok := data.GetOk("name_prefix"); ok {
+		cfg.NamePrefix = namePrefix.(string)
+	}
+	if groupNameTemplate, ok := data.GetOk("group_name_template"); ok {
+		cfg.GroupNameTemplate = groupNameTemplate.(string)
+	}
+	if ptNameTemplate, ok := data.GetOk("permission_target_name_template"); ok {
+		cfg.PermissionTargetNameTemplate = ptNameTemplate.(string)
+	}
+	if tokenUsernameTemplate, ok := data.GetOk("token_username_template"); ok {
+		cfg.TokenUsernameTemplate = tokenUsernameTemplate.(string)
+	}
+	if err := cfg.naming().validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	if err := putConfig(ctx, req.Storage, cfg); err != nil {
 		return nil, err
 	}
 
@@ -161,4 +211,13 @@ those credentials as well as default values for the backend in general.
 
 If multiple credentials are provided, it takes precendence on following order. 
 Bearer Token -> API Key -> Username/Password
+
+The credentials are stored apart from the rest of the config and both are
+seal wrapped. Reads return the credential in use as "auth_method" and a
+fingerprint of it as "credential_fingerprint", which changes when the
+credential is rotated.
+
+"name_prefix" and the name templates set how new roles name their groups,
+permission targets and token usernames, e.g. to share an Artifactory between
+mounts. Existing roles keep their names until moved with migrate-naming.
 `
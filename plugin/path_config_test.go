@@ -16,6 +16,7 @@ package artifactorysecrets
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
@@ -43,9 +44,16 @@ func TestConfig(t *testing.T) {
 		testConfigUpdate(t, backend, reqStorage, conf)
 
 		expected := map[string]interface{}{
-			"base_url":       "https://example.jfrog.io/example/",
-			"client_timeout": int64(15),
-			"max_ttl":        int64(600),
+			"base_url":                        "https://example.jfrog.io/example/",
+			"auth_method":                     "bearer_token",
+			"credential_fingerprint":          configFingerprint(t, backend, reqStorage),
+			"client_timeout":                  int64(15),
+			"max_ttl":                         int64(600),
+			"log_level":                       "warn",
+			"name_prefix":                     "vault-plugin",
+			"group_name_template":             "{{prefix}}.{{role_id}}",
+			"permission_target_name_template": "{{prefix}}.pt{{index}}.{{role_name}}",
+			"token_username_template":         "auto-{{prefix}}.{{role_name}}",
 		}
 
 		testConfigRead(t, backend, reqStorage, expected)
@@ -62,6 +70,13 @@ func TestConfig(t *testing.T) {
 
 		expected["client_timeout"] = int64(20)
 		testConfigRead(t, backend, reqStorage, expected)
+
+		testConfigUpdate(t, backend, reqStorage, map[string]interface{}{
+			"log_level": "debug",
+		})
+
+		expected["log_level"] = "debug"
+		testConfigRead(t, backend, reqStorage, expected)
 	})
 
 	t.Run("api_key", func(t *testing.T) {
@@ -81,9 +96,16 @@ func TestConfig(t *testing.T) {
 		testConfigUpdate(t, backend, reqStorage, conf)
 
 		expected := map[string]interface{}{
-			"base_url":       "https://example.jfrog.io/example/",
-			"client_timeout": int64(60),
-			"max_ttl":        int64(300),
+			"base_url":                        "https://example.jfrog.io/example/",
+			"auth_method":                     "none",
+			"credential_fingerprint":          "",
+			"client_timeout":                  int64(60),
+			"max_ttl":                         int64(300),
+			"log_level":                       "warn",
+			"name_prefix":                     "vault-plugin",
+			"group_name_template":             "{{prefix}}.{{role_id}}",
+			"permission_target_name_template": "{{prefix}}.pt{{index}}.{{role_name}}",
+			"token_username_template":         "auto-{{prefix}}.{{role_name}}",
 		}
 
 		testConfigRead(t, backend, reqStorage, expected)
@@ -107,15 +129,175 @@ func TestConfig(t *testing.T) {
 		testConfigUpdate(t, backend, reqStorage, conf)
 
 		expected := map[string]interface{}{
-			"base_url":       "https://example.jfrog.io/example/",
-			"client_timeout": int64(120),
-			"max_ttl":        int64(3600),
+			"base_url":                        "https://example.jfrog.io/example/",
+			"auth_method":                     "username_password",
+			"credential_fingerprint":          configFingerprint(t, backend, reqStorage),
+			"client_timeout":                  int64(120),
+			"max_ttl":                         int64(3600),
+			"log_level":                       "warn",
+			"name_prefix":                     "vault-plugin",
+			"group_name_template":             "{{prefix}}.{{role_id}}",
+			"permission_target_name_template": "{{prefix}}.pt{{index}}.{{role_name}}",
+			"token_username_template":         "auto-{{prefix}}.{{role_name}}",
 		}
 
 		testConfigRead(t, backend, reqStorage, expected)
 	})
 }
 
+func TestConfigAuthMethod(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		config   ConfigStorageEntry
+		expected string
+	}{
+		{name: "none", config: ConfigStorageEntry{}, expected: "none"},
+		{name: "bearer_token", config: ConfigStorageEntry{BearerToken: "t", ApiKey: "k"}, expected: "bearer_token"},
+		{name: "api_key", config: ConfigStorageEntry{ApiKey: "k", Username: "u", Password: "p"}, expected: "api_key"},
+		{name: "username_password", config: ConfigStorageEntry{Username: "u", Password: "p"}, expected: "username_password"},
+		{name: "username_only", config: ConfigStorageEntry{Username: "u"}, expected: "none"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.expected, test.config.authMethod())
+		})
+	}
+}
+
+func TestConfigInvalidLogLevel(t *testing.T) {
+	t.Parallel()
+
+	backend, reqStorage := getTestBackend(t, true)
+
+	resp, err := backend.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPrefix,
+		Data: map[string]interface{}{
+			"base_url":     "https://example.jfrog.io/example",
+			"bearer_token": "mybearertoken",
+			"log_level":    "verbose",
+		},
+		Storage: reqStorage,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError(), "expecting error")
+	assert.Contains(t, resp.Data["error"], "invalid log level 'verbose'")
+}
+
+func TestConfigNaming(t *testing.T) {
+	t.Parallel()
+
+	backend, reqStorage := getTestBackend(t, true)
+	testConfigUpdate(t, backend, reqStorage, map[string]interface{}{
+		"base_url":                        "https://example.jfrog.io/example",
+		"bearer_token":                    "mybearertoken",
+		"name_prefix":                     "vault-prod",
+		"permission_target_name_template": "{{prefix}}.{{role_name}}.{{index}}",
+	})
+
+	cfg, err := backend.(*ArtifactoryBackend).getConfig(context.Background(), reqStorage)
+	require.NoError(t, err)
+	assert.Equal(t, Naming{
+		Prefix:                   "vault-prod",
+		GroupTemplate:            "{{prefix}}.{{role_id}}",
+		PermissionTargetTemplate: "{{prefix}}.{{role_name}}.{{index}}",
+		TokenUsernameTemplate:    "auto-{{prefix}}.{{role_name}}",
+	}, cfg.naming())
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		err  string
+	}{
+		{name: "invalid_prefix", data: map[string]interface{}{"name_prefix": "vault/prod"}, err: "name prefix 'vault/prod' is not allowed"},
+		{name: "long_prefix", data: map[string]interface{}{"name_prefix": strings.Repeat("v", 27)}, err: "is too long"},
+		{name: "group_without_role_id", data: map[string]interface{}{"group_name_template": "{{prefix}}.group"}, err: "group_name_template needs {{role_id}}"},
+		{name: "role_name_in_group", data: map[string]interface{}{"group_name_template": "{{role_id}}.{{role_name}}"}, err: "group_name_template can't use {{role_name}}"},
+		{name: "pt_without_index", data: map[string]interface{}{"permission_target_name_template": "{{prefix}}.{{role_name}}"}, err: "permission_target_name_template needs {{index}}"},
+		{name: "index_in_group", data: map[string]interface{}{"group_name_template": "{{role_id}}.{{index}}"}, err: "group_name_template can't use {{index}}"},
+		{name: "unknown_placeholder", data: map[string]interface{}{"token_username_template": "{{team}}.{{role_name}}"}, err: "token_username_template can't use {{team}}"},
+		{name: "invalid_characters", data: map[string]interface{}{"token_username_template": "auto {{role_name}}"}, err: "token_username_template may only hold"},
+		{name: "no_room_for_role_names", data: map[string]interface{}{"group_name_template": "{{prefix}}." + strings.Repeat("g", 30) + ".{{role_id}}"}, err: "group_name_template is too long"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			resp, err := backend.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPrefix,
+				Data:      test.data,
+				Storage:   reqStorage,
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError(), "expecting error")
+			assert.Contains(t, resp.Data["error"], test.err)
+		})
+	}
+}
+
+func TestConfigCredentials(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	b, reqStorage := getTestBackend(t, true)
+	assert.Equal(t, []string{configPrefix, configCredentialsKey}, b.SpecialPaths().SealWrapStorage)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	})
+
+	// the secrets are only stored with the credentials
+	entry, err := reqStorage.Get(ctx, configPrefix)
+	require.NoError(t, err)
+	assert.NotContains(t, string(entry.Value), "mybearertoken")
+	creds, err := getConfigCredentials(ctx, reqStorage)
+	require.NoError(t, err)
+	require.NotNil(t, creds)
+	assert.Equal(t, "mybearertoken", creds.BearerToken)
+	assert.NotEmpty(t, creds.FingerprintKey)
+
+	fingerprint := configFingerprint(t, b, reqStorage)
+	assert.Regexp(t, `^hmac-sha256:[0-9a-f]{16}$`, fingerprint)
+
+	// the fingerprint only changes with the credential
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{"max_ttl": "50s"})
+	assert.Equal(t, fingerprint, configFingerprint(t, b, reqStorage))
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{"bearer_token": "rotatedtoken"})
+	assert.NotEqual(t, fingerprint, configFingerprint(t, b, reqStorage))
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{"bearer_token": "mybearertoken"})
+	assert.Equal(t, fingerprint, configFingerprint(t, b, reqStorage))
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{"bearer_token": "", "api_key": "mybearertoken"})
+	cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, reqStorage)
+	require.NoError(t, err)
+	assert.Equal(t, "api_key", cfg.authMethod())
+	assert.Equal(t, fingerprint, cfg.credentialFingerprint(), "fingerprints only depend on the secret")
+}
+
+// configFingerprint returns the credential fingerprint of a config read
+func configFingerprint(t *testing.T, b logical.Backend, s logical.Storage) string {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configPrefix,
+		Storage:   s,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError())
+	return resp.Data["credential_fingerprint"].(string)
+}
+
 func testConfigUpdate(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}) {
 	t.Helper()
 	resp, err := b.HandleRequest(context.Background(), &logical.Request{
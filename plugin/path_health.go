@@ -0,0 +1,103 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	healthPrefix = "health"
+)
+
+func (backend *ArtifactoryBackend) pathHealthRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	respData := map[string]interface{}{
+		"plugin_version":    buildVersion,
+		"plugin_commit":     buildCommit,
+		"plugin_build_date": buildDate,
+	}
+	resp := &logical.Response{Data: respData}
+
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	respData["configured"] = config != nil
+
+	if config != nil {
+		respData["base_url"] = config.BaseURL
+		respData["auth_method"] = config.authMethod()
+
+		// report the cached client before getClient replaces an expired one
+		backend.lock.RLock()
+		cached := backend.client
+		backend.lock.RUnlock()
+		respData["client_valid"] = cached != nil && cached.Valid()
+		if cached != nil {
+			respData["client_expiration"] = cached.Expiration().Format(time.RFC3339)
+		}
+
+		respData["reachable"] = false
+		if ac, err := backend.getClient(ctx, req.Storage); err != nil {
+			respData["error"] = err.Error()
+		} else if info, err := ac.SystemInfo(ctx); err != nil {
+			respData["error"] = err.Error()
+		} else {
+			respData["reachable"] = true
+			respData["artifactory_version"] = info.Version
+			respData["license_type"] = info.LicenseType
+		}
+	}
+
+	if lastSuccess := backend.breaker.lastSuccessfulCall(); !lastSuccess.IsZero() {
+		respData["last_successful_call"] = lastSuccess.Format(time.RFC3339)
+	}
+	respData["circuit_breaker"] = backend.breaker.status()
+
+	return resp, nil
+}
+
+func pathHealth(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: healthPrefix,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathHealthRead,
+			},
+			HelpSynopsis:    pathHealthHelpSyn,
+			HelpDescription: pathHealthHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathHealthHelpSyn = `Report the health of the connection to Artifactory.`
+const pathHealthHelpDesc = `
+This path reports whether the mount can reach Artifactory. It returns the plugin
+build version, the configured authentication method (never the credential), the
+Artifactory version and license type, the validity of the cached client, the time
+of the last successful Artifactory call and the state of the circuit breaker
+guarding Artifactory calls.
+
+After repeated failures to reach Artifactory, the circuit breaker opens and
+requests fail fast instead of waiting for the client timeout. While it is open,
+Artifactory is probed in the background and the circuit closes again as soon as
+Artifactory responds.
+`
@@ -0,0 +1,81 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathHealth(t *testing.T) {
+	t.Parallel()
+
+	t.Run("not_configured", func(t *testing.T) {
+		t.Parallel()
+		req, backend := newArtMockEnv(t)
+
+		resp, err := testHealthRead(req, backend, t)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		assert.Equal(t, false, resp.Data["configured"])
+		assert.Equal(t, "dev", resp.Data["plugin_version"])
+		assert.NotContains(t, resp.Data, "last_successful_call")
+
+		cbStatus, ok := resp.Data["circuit_breaker"].(map[string]interface{})
+		require.True(t, ok, "circuit breaker status should be returned")
+		assert.Equal(t, "closed", cbStatus["state"])
+		assert.Equal(t, 0, cbStatus["consecutive_failures"])
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Parallel()
+		req, backend := newArtMockEnv(t)
+		testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+			"base_url": "https://example.jfrog.io/example",
+			"api_key":  "myapikey",
+		})
+
+		resp, err := testHealthRead(req, backend, t)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		assert.Equal(t, true, resp.Data["configured"])
+		assert.Equal(t, "api_key", resp.Data["auth_method"])
+		assert.Equal(t, true, resp.Data["reachable"])
+		assert.Equal(t, true, resp.Data["client_valid"])
+		assert.Equal(t, "7.38.10", resp.Data["artifactory_version"])
+		assert.Equal(t, "Enterprise", resp.Data["license_type"])
+		assert.Contains(t, resp.Data, "client_expiration")
+		assert.Contains(t, resp.Data, "last_successful_call")
+
+		for _, v := range resp.Data {
+			assert.NotEqual(t, "myapikey", v, "credentials must never be returned")
+		}
+	})
+}
+
+func testHealthRead(req *logical.Request, b logical.Backend, t *testing.T) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.ReadOperation
+	req.Path = healthPrefix
+	req.Data = nil
+
+	return b.HandleRequest(context.Background(), req)
+}
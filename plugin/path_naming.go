@@ -0,0 +1,187 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const migrateNamingPath = "migrate-naming"
+
+var migrateNamingSchema = map[string]*framework.FieldSchema{
+	"role_name": {
+		Type:        framework.TypeString,
+		Description: "Name of the role to migrate. All the roles are migrated when empty",
+	},
+}
+
+// pathMigrateNaming moves roles created with another naming to the naming of the config
+func (backend *ArtifactoryBackend) pathMigrateNaming(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+
+	roleNames := []string{data.Get("role_name").(string)}
+	if roleNames[0] == "" {
+		if roleNames, err = backend.listRoleEntries(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+	}
+
+	migrated, unchanged := []string{}, []string{}
+	failed := map[string]string{}
+	var warnings []string
+	for _, roleName := range roleNames {
+		done, roleWarnings, err := backend.migrateRoleNaming(ctx, req, roleName, config.roleNaming())
+		if err != nil {
+			backend.Logger().Warn("unable to migrate the naming of role", "role_name", roleName, "error", err)
+			failed[roleName] = err.Error()
+			continue
+		}
+		for _, w := range roleWarnings {
+			warnings = append(warnings, fmt.Sprintf("role '%s': %s", roleName, w))
+		}
+		if done {
+			migrated = append(migrated, roleName)
+		} else {
+			unchanged = append(unchanged, roleName)
+		}
+	}
+
+	respData := map[string]interface{}{
+		"migrated":  migrated,
+		"unchanged": unchanged,
+	}
+	if len(failed) > 0 {
+		respData["failed"] = failed
+	}
+
+	return &logical.Response{Data: respData, Warnings: warnings}, nil
+}
+
+// migrateRoleNaming creates the Artifactory objects of the role under the naming, saves the role,
+// then removes the objects whose names changed. A failed migration leaves the role as it was.
+func (backend *ArtifactoryBackend) migrateRoleNaming(ctx context.Context, req *logical.Request, roleName string, naming *Naming) (migrated bool, warnings []string, err error) {
+	lock := backend.roleLock(roleName)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return false, nil, err
+	}
+	if role == nil {
+		return false, nil, fmt.Errorf("role '%s' does not exist", roleName)
+	}
+
+	target := *role
+	target.Naming = naming
+	if role.naming() == target.naming() {
+		return false, nil, nil
+	}
+
+	// permission targets with identity templates only exist once resolved
+	templated := hasIdentityTemplates(role.PermissionTargets)
+	if !templated {
+		if err := backend.createMigratedObjects(ctx, req, &target); err != nil {
+			return false, nil, err
+		}
+	}
+
+	if err := target.save(ctx, req.Storage); err != nil {
+		return false, nil, err
+	}
+
+	if !templated {
+		if cleanupErr := backend.deleteRenamedObjects(ctx, req, role, &target); cleanupErr != nil {
+			backend.Logger().Warn(
+				"unable to clean up the objects of the old naming of role.",
+				"role_name", role.Name, "errors", cleanupErr)
+			warnings = append(warnings, cleanupErr.Error())
+		}
+	}
+	// identity roles are created again with the new naming
+	if cleanupErr := backend.collectIdentityRoles(ctx, req, role.Name, true); cleanupErr != nil {
+		backend.Logger().Warn(
+			"unable to clean up identity roles of migrated role.",
+			"role_name", role.Name, "errors", cleanupErr)
+		warnings = append(warnings, cleanupErr.Error())
+	}
+
+	backend.Logger().Debug("migrated the naming of role", "name", role.Name)
+	return true, warnings, nil
+}
+
+func (backend *ArtifactoryBackend) createMigratedObjects(ctx context.Context, req *logical.Request, role *RoleStorageEntry) (err error) {
+	defer func() { backend.roleSynced(ctx, req.Storage, role.Name, err) }()
+
+	ac, err := backend.getClient(ctx, req.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+	return createRoleObjects(ctx, ac, role)
+}
+
+// deleteRenamedObjects removes the objects of the old role which the migrated role doesn't share
+func (backend *ArtifactoryBackend) deleteRenamedObjects(ctx context.Context, req *logical.Request, old, migrated *RoleStorageEntry) error {
+	principalRenamed := groupName(old) != groupName(migrated)
+	if old.usesServiceUser() {
+		principalRenamed = serviceUserName(old) != serviceUserName(migrated)
+	}
+	if principalRenamed {
+		if err := backend.tryDeleteRoleResources(ctx, req, old, nil, 0, true); err != nil {
+			return err
+		}
+	}
+
+	return backend.deleteUnsharedPermissionTargets(ctx, req, old, migrated, len(old.PermissionTargets))
+}
+
+func pathMigrateNaming(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: migrateNamingPath,
+			Fields:  migrateNamingSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathMigrateNaming,
+			},
+			HelpSynopsis:    pathMigrateNamingHelpSyn,
+			HelpDescription: pathMigrateNamingHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathMigrateNamingHelpSyn = `Move roles to the naming of the config.`
+const pathMigrateNamingHelpDesc = `
+Roles keep the names of their Artifactory objects when "name_prefix" or the
+name templates of the config change. This path moves "role_name", or every
+role when empty, to the naming of the config.
+
+The group or service user and the permission targets of a role are created
+under their new names before the role is saved, and the objects of the old
+naming are removed afterwards. Tokens issued for the old group stop working
+once it is removed. A failed migration leaves the role on its old naming and
+can be run again.
+`
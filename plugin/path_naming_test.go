@@ -0,0 +1,141 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testNamingPts = `[
+	{"repo": {"repositories": ["libs-local"], "operations": ["read"]}},
+	{"build": {"repositories": ["artifactory-build-info"], "operations": ["read"]}}
+]`
+
+func TestFakeArtifactoryNaming(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, fake := newFakeArtifactoryEnv(t)
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testNamingPts,
+	})
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"name_prefix":             "vault-prod",
+		"token_username_template": "{{prefix}}.token.{{role_name}}",
+	})
+
+	// new roles use the naming of the config, existing ones keep theirs
+	mustRoleCreate(req, backend, t, "cd", map[string]interface{}{
+		"permission_targets": testBundlePt,
+	})
+	cd, err := getRoleEntry(ctx, req.Storage, "cd")
+	require.NoError(t, err)
+	_, ok := fake.group("vault-prod." + cd.RoleID)
+	assert.True(t, ok, "group of the new role not created with the new naming")
+	_, ok = fake.permission("vault-prod.pt0.cd")
+	assert.True(t, ok, "permission target of the new role not created with the new naming")
+
+	ci, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	assert.Nil(t, ci.Naming)
+	_, ok = fake.group("vault-plugin." + ci.RoleID)
+	assert.True(t, ok, "group of the existing role renamed")
+
+	resp, err := testIssueToken(req, backend, t, "cd", map[string]interface{}{"role_name": "cd"})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, "vault-prod.token.cd", resp.Data["username"])
+
+	// the existing role moves to the new naming
+	resp, err = testMigrateNaming(req, backend, t, map[string]interface{}{})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, []string{"ci"}, resp.Data["migrated"])
+	assert.Equal(t, []string{"cd"}, resp.Data["unchanged"])
+	assert.NotContains(t, resp.Data, "failed")
+
+	ci, err = getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	require.NotNil(t, ci.Naming)
+	assert.Equal(t, "vault-prod", ci.Naming.Prefix)
+	for _, name := range []string{"vault-prod.pt0.ci", "vault-prod.pt1.ci"} {
+		_, ok = fake.permission(name)
+		assert.True(t, ok, "permission target %s not created", name)
+	}
+	_, ok = fake.group("vault-prod." + ci.RoleID)
+	assert.True(t, ok, "group not created with the new naming")
+	groups, pts := fake.counts()
+	assert.Equal(t, 2, groups, "old group not removed")
+	assert.Equal(t, 3, pts, "old permission targets not removed")
+
+	resp, err = testMigrateNaming(req, backend, t, map[string]interface{}{"role_name": "ci"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, resp.Data["migrated"])
+	assert.Equal(t, []string{"ci"}, resp.Data["unchanged"])
+
+}
+
+func TestFakeArtifactoryNamingFail(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, fake := newFakeArtifactoryEnv(t)
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testBundlePt,
+	})
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"name_prefix": "vault-prod",
+	})
+	fake.fail(http.MethodPut, fakePermissionsPath, http.StatusBadRequest, 1)
+
+	resp, err := testMigrateNaming(req, backend, t, map[string]interface{}{})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, []string{}, resp.Data["migrated"])
+	require.Contains(t, resp.Data, "failed")
+	assert.Contains(t, resp.Data["failed"].(map[string]string)["ci"], "Failed to create/update a permission target")
+
+	// the role keeps working on its old naming, and migrating again succeeds
+	role, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	assert.Nil(t, role.Naming)
+	_, ok := fake.permission(permissionTargetName("ci", 0))
+	assert.True(t, ok, "old permission target removed")
+
+	resp, err = testMigrateNaming(req, backend, t, map[string]interface{}{"role_name": "ci"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ci"}, resp.Data["migrated"])
+	_, ok = fake.permission(permissionTargetName("ci", 0))
+	assert.False(t, ok, "old permission target not removed")
+
+	resp, err = testMigrateNaming(req, backend, t, map[string]interface{}{"role_name": "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"missing": "role 'missing' does not exist"}, resp.Data["failed"])
+}
+
+func testMigrateNaming(req *logical.Request, b logical.Backend, t *testing.T, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.UpdateOperation
+	req.Path = migrateNamingPath
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
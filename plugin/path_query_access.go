@@ -0,0 +1,173 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const queryAccessPath = "query/access"
+
+// roleAccess is what a role grants on a repository path
+type roleAccess struct {
+	operations   map[string]bool
+	repositories map[string]bool
+	templated    bool
+	conditional  bool
+}
+
+func (access roleAccess) details() map[string]interface{} {
+	return map[string]interface{}{
+		"operations":         sortedKeys(access.operations),
+		"repositories":       sortedKeys(access.repositories),
+		"identity_templated": access.templated,
+		"conditional":        access.conditional,
+	}
+}
+
+// roleAccessTo returns what the repo permission targets of the role grant on the repository path,
+// or nil when they grant nothing.
+func roleAccessTo(role *RoleStorageEntry, repository, path string) *roleAccess {
+	var access *roleAccess
+	for _, pt := range role.PermissionTargets {
+		p := pt.Repo
+		if p == nil || !p.covers(repository) {
+			continue
+		}
+		allowed, conditional := p.allows(path)
+		if !allowed {
+			continue
+		}
+		if access == nil {
+			access = &roleAccess{operations: map[string]bool{}, repositories: map[string]bool{}}
+		}
+		for _, op := range p.Operations {
+			access.operations[op] = true
+		}
+		for _, repo := range p.Repositories {
+			if repo == repository || isPseudoRepository(repo) {
+				access.repositories[repo] = true
+			}
+		}
+		access.templated = access.templated || pt.hasIdentityTemplates()
+		access.conditional = access.conditional || conditional
+	}
+	return access
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (backend *ArtifactoryBackend) pathQueryAccess(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	repository := data.Get("repository").(string)
+	if repository == "" {
+		return logical.ErrorResponse("repository not supplied"), nil
+	}
+	path := data.Get("path").(string)
+	operation := data.Get("operation").(string)
+	if operation != "" {
+		if err := validateOperations([]string{operation}); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	roleNames, err := backend.listRoleEntries(ctx, req.Storage)
+	if err != nil {
+		return logical.ErrorResponse("Error listing roles"), err
+	}
+
+	roles := map[string]interface{}{}
+	for _, roleName := range roleNames {
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		if err != nil {
+			return logical.ErrorResponse("Error reading role"), err
+		}
+		if role == nil {
+			continue
+		}
+		access := roleAccessTo(role, repository, path)
+		if access == nil || (operation != "" && !access.operations[operation]) {
+			continue
+		}
+		roles[roleName] = access.details()
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"repository": repository,
+			"path":       path,
+			"roles":      roles,
+		},
+	}, nil
+}
+
+func pathQueryAccess(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: queryAccessPath,
+			Fields: map[string]*framework.FieldSchema{
+				"repository": {
+					Type:        framework.TypeString,
+					Description: "The repository to query access to",
+				},
+				"path": {
+					Type:        framework.TypeString,
+					Description: "The path in the repository. Defaults to the repository root",
+				},
+				"operation": {
+					Type:        framework.TypeString,
+					Description: "Only return the roles granting this operation",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   backend.pathQueryAccess,
+				logical.UpdateOperation: backend.pathQueryAccess,
+			},
+			HelpSynopsis:    pathQueryAccessHelpSyn,
+			HelpDescription: pathQueryAccessHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathQueryAccessHelpSyn = `Query which roles grant access to a repository path.`
+const pathQueryAccessHelpDesc = `
+This path returns the roles whose permission targets grant access to "path" in
+"repository", with the operations they grant. It only reads the roles, no call
+is made to Artifactory.
+
+The path is matched against the include and exclude patterns of the roles with
+Artifactory's Ant-style rules: "?" matches one character, "*" any characters
+within a folder and "**" any number of folders. Wildcards in the queried path
+are not expanded, so "com/acme/**" finds the roles granting access to all of
+"com/acme".
+
+Permission targets on pseudo repositories such as "ANY LOCAL" are reported for
+any repository, as resolving them needs Artifactory. Roles whose patterns use identity
+templates are reported as "identity_templated". When such a pattern decides
+whether the path is included or excluded, the role is reported as "conditional",
+as its access depends on the caller.
+`
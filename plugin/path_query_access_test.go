@@ -0,0 +1,167 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathQueryAccess(t *testing.T) {
+	t.Parallel()
+
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	})
+	mustRoleCreate(req, backend, t, "acme-publish", map[string]interface{}{
+		"permission_targets": `[{"repo": {"include_patterns": ["com/acme/**"], "exclude_patterns": ["com/acme/internal/**"], "repositories": ["libs-release-local"], "operations": ["read", "write"]}}]`,
+	})
+	mustRoleCreate(req, backend, t, "release-read", map[string]interface{}{
+		"permission_targets": `[{"repo": {"repositories": ["libs-release-local", "libs-snapshot-local"], "operations": ["read"]}}]`,
+	})
+	mustRoleCreate(req, backend, t, "any-local", map[string]interface{}{
+		"permission_targets": `[{"repo": {"include_patterns": ["**/*.jar"], "repositories": ["ANY LOCAL"], "operations": ["delete"]}}]`,
+	})
+	mustRoleCreate(req, backend, t, "team-publish", map[string]interface{}{
+		"permission_targets": `[{"repo": {"include_patterns": ["com/{{identity.entity.metadata.team}}/**"], "repositories": ["libs-release-local"], "operations": ["write"]}}]`,
+	})
+	mustRoleCreate(req, backend, t, "team-exclude", map[string]interface{}{
+		"permission_targets": `[{"repo": {"include_patterns": ["**"], "exclude_patterns": ["/{{identity.entity.metadata.team}}/**", "private/**"], "repositories": ["team-local"], "operations": ["read"]}}]`,
+	})
+	mustRoleCreate(req, backend, t, "builds", map[string]interface{}{
+		"permission_targets": `[{"build": {"repositories": ["artifactory-build-info"], "operations": ["manage"]}}]`,
+	})
+
+	tests := []struct {
+		name        string
+		data        map[string]interface{}
+		expected    []string
+		templated   []string
+		conditional []string
+	}{
+		{
+			name:        "write_subtree",
+			data:        map[string]interface{}{"repository": "libs-release-local", "path": "com/acme/**", "operation": "write"},
+			expected:    []string{"acme-publish", "team-publish"},
+			templated:   []string{"team-publish"},
+			conditional: []string{"team-publish"},
+		},
+		{
+			name:        "excluded_path",
+			data:        map[string]interface{}{"repository": "libs-release-local", "path": "com/acme/internal/secret.jar", "operation": "write"},
+			expected:    []string{"team-publish"},
+			templated:   []string{"team-publish"},
+			conditional: []string{"team-publish"},
+		},
+		{
+			name:        "any_operation",
+			data:        map[string]interface{}{"repository": "libs-release-local", "path": "com/acme/lib/lib-1.0.jar"},
+			expected:    []string{"acme-publish", "release-read", "any-local", "team-publish"},
+			templated:   []string{"team-publish"},
+			conditional: []string{"team-publish"},
+		},
+		{
+			// the templated exclude may exclude the path for some callers only
+			name:        "templated_exclude",
+			data:        map[string]interface{}{"repository": "team-local", "path": "acme/lib.pom"},
+			expected:    []string{"team-exclude"},
+			templated:   []string{"team-exclude"},
+			conditional: []string{"team-exclude"},
+		},
+		{
+			name:     "concrete_exclude",
+			data:     map[string]interface{}{"repository": "team-local", "path": "private/lib.pom"},
+			expected: []string{},
+		},
+		{
+			name:     "other_repository",
+			data:     map[string]interface{}{"repository": "libs-snapshot-local", "path": "org/other/lib.pom"},
+			expected: []string{"release-read"},
+		},
+		{
+			name:     "repository_root",
+			data:     map[string]interface{}{"repository": "libs-release-local"},
+			expected: []string{"release-read"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := testQueryAccess(req, backend, t, test.data)
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+			roles := resp.Data["roles"].(map[string]interface{})
+			names := []string{}
+			for name, access := range roles {
+				names = append(names, name)
+				templated := access.(map[string]interface{})["identity_templated"].(bool)
+				assert.Equal(t, contains(test.templated, name), templated, name)
+				conditional := access.(map[string]interface{})["conditional"].(bool)
+				assert.Equal(t, contains(test.conditional, name), conditional, name)
+			}
+			assert.ElementsMatch(t, test.expected, names)
+		})
+	}
+
+	t.Run("details", func(t *testing.T) {
+		resp, err := testQueryAccess(req, backend, t, map[string]interface{}{"repository": "libs-release-local", "path": "com/acme/lib.jar"})
+		require.NoError(t, err)
+		roles := resp.Data["roles"].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{
+			"operations":         []string{"delete"},
+			"repositories":       []string{"ANY LOCAL"},
+			"identity_templated": false,
+			"conditional":        false,
+		}, roles["any-local"])
+		assert.Equal(t, []string{"read", "write"}, roles["acme-publish"].(map[string]interface{})["operations"])
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		resp, err := testQueryAccess(req, backend, t, map[string]interface{}{"path": "com/acme"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "repository not supplied")
+
+		resp, err = testQueryAccess(req, backend, t, map[string]interface{}{"repository": "libs-release-local", "operation": "publish"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "operation 'publish' is not allowed")
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func testQueryAccess(req *logical.Request, b logical.Backend, t *testing.T, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.ReadOperation
+	req.Path = queryAccessPath
+	req.Data = data
+	return b.HandleRequest(context.Background(), req)
+}
@@ -16,8 +16,9 @@ package artifactorysecrets
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
@@ -45,6 +46,50 @@ var createRoleSchema = map[string]*framework.FieldSchema{
 		Type:        framework.TypeString,
 		Description: "List of permission target configurations",
 	},
+	"docker_registry": {
+		Type:        framework.TypeString,
+		Description: "Docker registry host for docker formatted tokens. Defaults to the host of the configured base url",
+	},
+	"reference_token": {
+		Type:        framework.TypeBool,
+		Description: "Whether tokens of the role come with a JFrog Access reference token by default, which is also used in formatted credentials",
+	},
+	"mode": {
+		Type:        framework.TypeString,
+		Description: "Whether permission targets are granted to a group (\"group\", the default) or to a service user of the role (\"user\"). Can't be changed once the role exists",
+	},
+	"project_key": {
+		Type:        framework.TypeString,
+		Description: "Key of the JFrog project whose project_roles tokens are scoped to. Setting it empty removes the role from its project",
+	},
+	"project_roles": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Project roles of the project_key given to the group of the role and to its tokens",
+	},
+	"template": {
+		Type:        framework.TypeString,
+		Description: "Name of the role template to render the permission targets from, instead of supplying permission_targets",
+	},
+	"params": {
+		Type:        framework.TypeKVPairs,
+		Description: "Parameters of the role template, as key value pairs",
+	},
+	"description": {
+		Type:        framework.TypeString,
+		Description: "Description of the role, also used for its Artifactory group",
+	},
+	"owner": {
+		Type:        framework.TypeString,
+		Description: "The team or person owning the role",
+	},
+	"labels": {
+		Type:        framework.TypeKVPairs,
+		Description: "Labels to filter roles by, as key value pairs",
+	},
+	"metadata": {
+		Type:        framework.TypeKVPairs,
+		Description: "Free-form metadata of the role, as key value pairs",
+	},
 }
 
 // remove the specified role from the storage
@@ -54,6 +99,19 @@ func (backend *ArtifactoryBackend) pathRoleDelete(ctx context.Context, req *logi
 		return logical.ErrorResponse("Unable to remove, missing role name"), nil
 	}
 
+	warnings, err := backend.deleteRole(ctx, req, roleName)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Unable to remove role %s", roleName)), err
+	} else if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
+	return nil, nil
+}
+
+// deleteRole removes the role from storage and cleans up its Artifactory resources.
+// Failing to clean up is reported as warnings as the role is already gone.
+func (backend *ArtifactoryBackend) deleteRole(ctx context.Context, req *logical.Request, roleName string) ([]string, error) {
 	lock := backend.roleLock(roleName)
 	lock.RLock()
 	defer lock.RUnlock()
@@ -70,15 +128,35 @@ func (backend *ArtifactoryBackend) pathRoleDelete(ctx context.Context, req *logi
 	deleteGroup := true
 
 	if err := backend.deleteRoleEntry(ctx, req.Storage, roleName); err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("Unable to remove role %s", roleName)), err
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, roleSyncKey(roleName)); err != nil {
+		return nil, err
+	}
+	if role.RoleID != roleID(roleName) {
+		if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", roleIDsPrefix, role.RoleID)); err != nil {
+			return nil, err
+		}
 	}
 
 	// Try to clean up resources.
-	if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, role.PermissionTargets, 0, deleteGroup); cleanupErr != nil {
+	var warnings []string
+	if !hasIdentityTemplates(role.PermissionTargets) {
+		if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, role.PermissionTargets, 0, deleteGroup); cleanupErr != nil {
+			backend.Logger().Warn(
+				"unable to clean up unused artifactory resources from deleted role.",
+				"role_name", roleName, "errors", cleanupErr)
+			warnings = append(warnings, cleanupErr.Error())
+		}
+	}
+	if cleanupErr := backend.collectIdentityRoles(ctx, req, roleName, true); cleanupErr != nil {
 		backend.Logger().Warn(
-			"unable to clean up unused artifactory resources from deleted role.",
+			"unable to clean up identity roles from deleted role.",
 			"role_name", roleName, "errors", cleanupErr)
-		return &logical.Response{Warnings: []string{cleanupErr.Error()}}, nil
+		warnings = append(warnings, cleanupErr.Error())
+	}
+	if len(warnings) > 0 {
+		return warnings, nil
 	}
 
 	backend.Logger().Debug("successfully deleted role and artifactory resources", "name", roleName)
@@ -104,37 +182,183 @@ func (backend *ArtifactoryBackend) pathRoleRead(ctx context.Context, req *logica
 			"token_ttl":          int64(role.TokenTTL / time.Second),
 			"max_ttl":            int64(role.MaxTTL / time.Second),
 			"permission_targets": role.RawPermissionTargets,
+			"docker_registry":    role.DockerRegistry,
+			"reference_token":    role.ReferenceToken,
+			"mode":               role.mode(),
+			"project_key":        role.ProjectKey,
+			"project_roles":      role.ProjectRoles,
+			"template":           role.Template,
+			"params":             role.TemplateParams,
+			"description":        role.Description,
+			"owner":              role.Owner,
+			"labels":             role.Labels,
+			"metadata":           role.Metadata,
 		},
 	}, nil
 }
 
-// read the current role from the inputs and return it if it exists
+// list the roles, optionally filtered and with their details
 func (backend *ArtifactoryBackend) pathRolesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roles, err := backend.listRoleEntries(ctx, req.Storage)
 	if err != nil {
 		return logical.ErrorResponse("Error listing roles"), err
 	}
-	return logical.ListResponse(roles), nil
+
+	repository := data.Get("repository").(string)
+	operation := data.Get("operation").(string)
+	labels := data.Get("label").(map[string]string)
+	detailed := data.Get("detailed").(bool)
+	if operation != "" {
+		if err := validateOperations([]string{operation}); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if repository == "" && operation == "" && len(labels) == 0 && !detailed {
+		return logical.ListResponse(roles), nil
+	}
+
+	matching := []string{}
+	keyInfo := map[string]interface{}{}
+	for _, roleName := range roles {
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		if err != nil {
+			return logical.ErrorResponse("Error reading role"), err
+		}
+		if role == nil || !role.hasLabels(labels) || !role.grants(repository, operation) {
+			continue
+		}
+		matching = append(matching, roleName)
+
+		if detailed {
+			status, err := getRoleSyncStatus(ctx, req.Storage, roleName)
+			if err != nil {
+				return logical.ErrorResponse("Error reading role sync status"), err
+			}
+			keyInfo[roleName] = roleListDetails(role, status)
+		}
+	}
+
+	if detailed {
+		return logical.ListResponseWithInfo(matching, keyInfo), nil
+	}
+	return logical.ListResponse(matching), nil
 }
 
-func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+func roleListDetails(role *RoleStorageEntry, status *RoleSyncStatus) map[string]interface{} {
+	details := map[string]interface{}{
+		"token_ttl":    int64(role.TokenTTL / time.Second),
+		"max_ttl":      int64(role.MaxTTL / time.Second),
+		"repositories": roleRepositories(role),
+		"operations":   roleOperations(role),
+		"description":  role.Description,
+		"owner":        role.Owner,
+		"labels":       role.Labels,
+		"template":     role.Template,
+	}
+	if status != nil {
+		details["last_sync"] = status.details()
+	}
+	return details
+}
 
-	roleDetails := func(role *RoleStorageEntry) map[string]interface{} {
-		return map[string]interface{}{
-			"role_id":            role.RoleID,
-			"role_name":          role.Name,
-			"permission_targets": role.RawPermissionTargets,
+// roleUpdate holds the user supplied fields of a role create or update.
+// Unset fields leave the stored role untouched.
+type roleUpdate struct {
+	permissionTargets *string
+	tokenTTL          time.Duration
+	maxTTL            time.Duration
+	dockerRegistry    *string
+	referenceToken    *bool
+	mode              *string
+	projectKey        *string
+	projectRoles      []string
+	template          *string
+	templateParams    map[string]string
+	description       *string
+	owner             *string
+	labels            map[string]string
+	metadata          map[string]string
+}
+
+// roleUpdateFromFieldData collects the supplied role fields of a request
+func roleUpdateFromFieldData(data *framework.FieldData) (roleUpdate, error) {
+	var update roleUpdate
+
+	if ptsRaw, ok := data.GetOk("permission_targets"); ok {
+		pts, ok := ptsRaw.(string)
+		if !ok {
+			return update, errors.New("permission targets are not a string")
 		}
+		update.permissionTargets = &pts
+	}
+	if maxttlRaw, ok := data.GetOk("max_ttl"); ok && maxttlRaw.(int) > 0 {
+		update.maxTTL = time.Duration(maxttlRaw.(int)) * time.Second
+	}
+	if ttlRaw, ok := data.GetOk("token_ttl"); ok && ttlRaw.(int) > 0 {
+		update.tokenTTL = time.Duration(ttlRaw.(int)) * time.Second
+	}
+	if dockerRegistry, ok := data.GetOk("docker_registry"); ok {
+		registry := dockerRegistry.(string)
+		update.dockerRegistry = &registry
+	}
+	if referenceToken, ok := data.GetOk("reference_token"); ok {
+		reference := referenceToken.(bool)
+		update.referenceToken = &reference
+	}
+	if mode, ok := data.GetOk("mode"); ok {
+		m := mode.(string)
+		update.mode = &m
+	}
+	if projectKey, ok := data.GetOk("project_key"); ok {
+		key := projectKey.(string)
+		update.projectKey = &key
+	}
+	if projectRoles, ok := data.GetOk("project_roles"); ok {
+		update.projectRoles = projectRoles.([]string)
+	}
+	if template, ok := data.GetOk("template"); ok {
+		name := template.(string)
+		update.template = &name
+	}
+	if params, ok := data.GetOk("params"); ok {
+		update.templateParams = params.(map[string]string)
 	}
+	if description, ok := data.GetOk("description"); ok {
+		desc := description.(string)
+		update.description = &desc
+	}
+	if owner, ok := data.GetOk("owner"); ok {
+		o := owner.(string)
+		update.owner = &o
+	}
+	if labels, ok := data.GetOk("labels"); ok {
+		update.labels = labels.(map[string]string)
+	}
+	if metadata, ok := data.GetOk("metadata"); ok {
+		update.metadata = metadata.(map[string]string)
+	}
+
+	return update, nil
+}
+
+func roleDetails(role *RoleStorageEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"role_id":            role.RoleID,
+		"role_name":          role.Name,
+		"permission_targets": role.RawPermissionTargets,
+	}
+}
 
+func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roleName := data.Get("name").(string)
 	if roleName == "" {
 		return logical.ErrorResponse("Role name not supplied"), nil
 	}
 
-	lock := backend.roleLock(roleName)
-	lock.RLock()
-	defer lock.RUnlock()
+	update, err := roleUpdateFromFieldData(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
 	config, err := backend.getConfig(ctx, req.Storage)
 	if err != nil {
@@ -144,91 +368,226 @@ func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req
 		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
 	}
 
+	role, warnings, err := backend.applyRole(ctx, req, config, roleName, update, req.Operation == logical.CreateOperation)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	} else if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings, Data: roleDetails(role)}, nil
+	}
+
+	return &logical.Response{Data: roleDetails(role)}, nil
+}
+
+// applyRole validates the update against the stored role and the config, then saves the role,
+// syncing its group and permission targets with Artifactory when the permission targets changed.
+// The returned error is meant for the caller of the request.
+func (backend *ArtifactoryBackend) applyRole(ctx context.Context, req *logical.Request, config *ConfigStorageEntry, roleName string, update roleUpdate, isCreate bool) (*RoleStorageEntry, []string, error) {
+	lock := backend.roleLock(roleName)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return backend.applyRoleLocked(ctx, req, config, roleName, update, isCreate)
+}
+
+// applyRoleLocked is applyRole for callers already holding the lock of the role
+func (backend *ArtifactoryBackend) applyRoleLocked(ctx context.Context, req *logical.Request, config *ConfigStorageEntry, roleName string, update roleUpdate, isCreate bool) (*RoleStorageEntry, []string, error) {
 	role, err := getRoleEntry(ctx, req.Storage, roleName)
 	if err != nil {
-		return logical.ErrorResponse("Error reading role"), nil
+		return nil, nil, errors.New("Error reading role")
 	}
 
+	stored := role != nil
 	if role == nil {
 		role = &RoleStorageEntry{
-			Name: roleName,
+			Name:   roleName,
+			Naming: config.roleNaming(),
+		}
+		if role.RoleID, err = newRoleID(ctx, req.Storage, roleName); err != nil {
+			return nil, nil, errors.New("Error reading role")
 		}
-		role.RoleID = roleID(roleName)
 	}
 
-	isCreate := req.Operation == logical.CreateOperation
+	// Role templates render the permission targets, explicit permission targets detach the role from its template
+	if update.template != nil && *update.template != "" && update.permissionTargets != nil {
+		return nil, nil, errors.New("permission targets and template are mutually exclusive")
+	}
+	if update.permissionTargets != nil || (update.template != nil && *update.template == "") {
+		role.Template = ""
+		role.TemplateParams = nil
+	}
+	if update.template != nil && *update.template != "" {
+		role.Template = *update.template
+	}
+	if update.templateParams != nil {
+		if role.Template == "" {
+			return nil, nil, errors.New("params are only supported with a template")
+		}
+		role.TemplateParams = update.templateParams
+	}
+	if role.Template != "" && (update.template != nil || update.templateParams != nil) {
+		tmpl, err := getRoleTemplateEntry(ctx, req.Storage, role.Template)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading role template %s", role.Template)
+		}
+		if tmpl == nil {
+			return nil, nil, fmt.Errorf("role template '%s' does not exist", role.Template)
+		}
+		rendered, err := tmpl.render(role.TemplateParams)
+		if err != nil {
+			return nil, nil, err
+		}
+		update.permissionTargets = &rendered
+	}
 
-	// Permission Targets
-	ptsRaw, newPermissionTargets := data.GetOk("permission_targets")
-	if newPermissionTargets {
-		pts, ok := ptsRaw.(string)
-		if !ok {
-			return logical.ErrorResponse("permission targets are not a string"), nil
+	// Mode, group roles are stored without one
+	if update.mode != nil {
+		mode := *update.mode
+		if mode == "" {
+			mode = roleModeGroup
+		}
+		if err := validateMode(mode, ""); err != nil {
+			return nil, nil, err
 		}
-		if pts == "" {
-			return logical.ErrorResponse("permission targets are empty"), nil
+		if stored && mode != role.mode() {
+			return nil, nil, fmt.Errorf("the mode of role '%s' can't be changed", role.Name)
+		}
+		role.Mode = mode
+		if mode == roleModeGroup {
+			role.Mode = ""
 		}
 	}
 
-	if isCreate && !newPermissionTargets {
-		return logical.ErrorResponse("permission targets are required for new role"), nil
+	// Project roles, clearing the project key clears them too
+	oldProjectKey, oldProjectRoles := role.ProjectKey, role.ProjectRoles
+	if update.projectKey != nil {
+		role.ProjectKey = *update.projectKey
+		if role.ProjectKey == "" && update.projectRoles == nil {
+			role.ProjectRoles = nil
+		}
+	}
+	if update.projectRoles != nil {
+		role.ProjectRoles = update.projectRoles
+	}
+	if len(role.ProjectRoles) == 0 {
+		role.ProjectRoles = nil
+	}
+	if err := validateProject(role.ProjectKey, role.ProjectRoles); err != nil {
+		return nil, nil, err
+	}
+	if err := validateMode(role.Mode, role.ProjectKey); err != nil {
+		return nil, nil, err
+	}
+	projectChanged := role.ProjectKey != oldProjectKey || strings.Join(role.ProjectRoles, ",") != strings.Join(oldProjectRoles, ",")
+
+	// Permission Targets, which roles with project roles can do without
+	newPermissionTargets := update.permissionTargets != nil
+	if isCreate && !newPermissionTargets && role.ProjectKey == "" {
+		return nil, nil, errors.New("permission targets are required for new role")
+	}
+	if newPermissionTargets && *update.permissionTargets == "" && role.ProjectKey == "" {
+		return nil, nil, errors.New("permission targets are empty")
+	}
+	if !newPermissionTargets && role.RawPermissionTargets == "" && role.ProjectKey == "" {
+		return nil, nil, errors.New("permission targets are required for roles without project roles")
 	}
 
-	maxttlRaw, ok := data.GetOk("max_ttl")
-	if ok && maxttlRaw.(int) > 0 {
-		role.MaxTTL = time.Duration(maxttlRaw.(int)) * time.Second
+	if update.maxTTL > 0 {
+		role.MaxTTL = update.maxTTL
 	} else if role.MaxTTL == time.Duration(0) {
 		role.MaxTTL = time.Duration(createRoleSchema["max_ttl"].Default.(int)) * time.Second
 	}
 
-	ttlRaw, ok := data.GetOk("token_ttl")
-	if ok && ttlRaw.(int) > 0 {
-		role.TokenTTL = time.Duration(ttlRaw.(int)) * time.Second
+	if update.tokenTTL > 0 {
+		role.TokenTTL = update.tokenTTL
 	} else if role.TokenTTL == time.Duration(0) {
 		role.TokenTTL = time.Duration(createRoleSchema["token_ttl"].Default.(int)) * time.Second
 	}
 
-	if role.MaxTTL > config.MaxTTL {
-		return logical.ErrorResponse(fmt.Sprintf("role max ttl is greater than config max ttl '%d'", config.MaxTTL)), nil
+	if update.dockerRegistry != nil {
+		role.DockerRegistry = *update.dockerRegistry
 	}
-	if role.TokenTTL > role.MaxTTL {
-		return logical.ErrorResponse(fmt.Sprintf("role token ttl is greater than role max ttl '%d'", role.MaxTTL)), nil
+	if update.referenceToken != nil {
+		role.ReferenceToken = *update.referenceToken
+	}
+
+	oldDescription := role.Description
+	if update.description != nil {
+		role.Description = *update.description
+	}
+	if update.owner != nil {
+		role.Owner = *update.owner
+	}
+	if update.labels != nil {
+		if err := validateLabels(update.labels); err != nil {
+			return nil, nil, err
+		}
+		role.Labels = update.labels
+	}
+	if update.metadata != nil {
+		role.Metadata = update.metadata
+	}
+	if len(role.Labels) == 0 {
+		role.Labels = nil
+	}
+	if len(role.Metadata) == 0 {
+		role.Metadata = nil
+	}
+
+	if err := role.validateTTLs(config); err != nil {
+		return nil, nil, err
 	}
 	// If no new permission targets or new permission targets are exactly same as old permission targets,
 	// just return without updating permission targets
-	if !newPermissionTargets || role.permissionTargetsHash() == getStringHash(ptsRaw.(string)) {
+	if !newPermissionTargets || role.permissionTargetsHash() == getStringHash(*update.permissionTargets) {
 		backend.Logger().Debug("No net new permission targets are added for role", "role_name", role.Name)
+		if role.ProjectKey != "" && hasIdentityTemplates(role.PermissionTargets) {
+			return nil, nil, errors.New("project roles are not supported with identity templates")
+		}
+		// the group description and project membership follow the role, new roles without
+		// permission targets only have a group
+		if (isCreate || role.Description != oldDescription || projectChanged) && !hasIdentityTemplates(role.PermissionTargets) {
+			if err := backend.updateRoleGroup(ctx, req, role); err != nil {
+				return nil, nil, err
+			}
+		}
 		if err := role.save(ctx, req.Storage); err != nil {
-			return logical.ErrorResponse(err.Error()), nil
+			return nil, nil, err
 		}
-		return &logical.Response{Data: roleDetails(role)}, nil
+		return role, backend.leaveOldProject(ctx, req, role, oldProjectKey, isCreate), nil
 	}
 
 	// new permission targets, update role
-	var pts []PermissionTarget
-	err = json.Unmarshal([]byte(ptsRaw.(string)), &pts)
-	if err != nil {
-		return logical.ErrorResponse("Error unmarshal permission targets. Expecting list of permission targets - " + err.Error()), nil
-	}
-	if len(pts) == 0 {
-		return logical.ErrorResponse("Failed to parse any permission targets from given permission targets JSON"), nil
-	}
-	for _, pt := range pts {
-		if err = pt.assertValid(); err != nil {
-			return logical.ErrorResponse("Failed to validate a permission target - " + err.Error()), nil
+	pts := []PermissionTarget{}
+	if *update.permissionTargets != "" {
+		if pts, err = parsePermissionTargets(*update.permissionTargets); err != nil {
+			return nil, nil, err
 		}
 	}
-	role.RawPermissionTargets = ptsRaw.(string)
+	if role.ProjectKey != "" && hasIdentityTemplates(pts) {
+		return nil, nil, errors.New("project roles are not supported with identity templates")
+	}
+	role.RawPermissionTargets = *update.permissionTargets
 
 	// save role with new permission targets
 	warnings, err := backend.saveRoleWithNewPermissionTargets(ctx, req, role, pts)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
-	} else if len(warnings) > 0 {
-		return &logical.Response{Warnings: warnings, Data: roleDetails(role)}, nil
+		return nil, nil, err
 	}
 
-	return &logical.Response{Data: roleDetails(role)}, nil
+	return role, append(warnings, backend.leaveOldProject(ctx, req, role, oldProjectKey, isCreate)...), nil
+}
+
+// leaveOldProject removes the group of a synced role from the project it moved away from,
+// failing to do so is a warning as the role is already saved
+func (backend *ArtifactoryBackend) leaveOldProject(ctx context.Context, req *logical.Request, role *RoleStorageEntry, oldProjectKey string, isCreate bool) []string {
+	if isCreate || oldProjectKey == "" || oldProjectKey == role.ProjectKey {
+		return nil
+	}
+	if err := backend.removeRoleFromProject(ctx, req, role, oldProjectKey); err != nil {
+		backend.Logger().Warn("unable to remove the group of a role from its old project", "role_name", role.Name, "errors", err)
+		return []string{err.Error()}
+	}
+	return nil
 }
 
 func (backend *ArtifactoryBackend) pathRoleExistenceCheck(roleFieldName string) framework.ExistenceFunc {
@@ -269,10 +628,30 @@ func pathRoleList(backend *ArtifactoryBackend) []*framework.Path {
 	paths := []*framework.Path{
 		{
 			Pattern: fmt.Sprintf("%s?/?", rolesPrefix),
+			Fields: map[string]*framework.FieldSchema{
+				"label": {
+					Type:        framework.TypeKVPairs,
+					Description: "Only list the roles with all of these labels, as key value pairs",
+				},
+				"repository": {
+					Type:        framework.TypeString,
+					Description: "Only list the roles with permission targets on this repository",
+				},
+				"operation": {
+					Type:        framework.TypeString,
+					Description: "Only list the roles granting this operation, on the repository if one is supplied",
+				},
+				"detailed": {
+					Type:        framework.TypeBool,
+					Description: "Return the TTLs, repositories, operations, ownership and last sync status of each role",
+					Default:     false,
+				},
+			},
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.ListOperation: backend.pathRolesList,
 			},
-			HelpSynopsis: pathListRoleHelpSyn,
+			HelpSynopsis:    pathListRoleHelpSyn,
+			HelpDescription: pathListRoleHelpDesc,
 		},
 	}
 	return paths
@@ -321,6 +700,28 @@ At least one of repo or build is required
 
 Allowed operations are "read", "write", "annotate",
 "delete", "manage", "managedXrayMeta", "distribute"
+
+A role can record a "description", which is also used as the description of its
+Artifactory group, an "owner", and "labels" and "metadata" as key value pairs.
+Roles can be listed by label.
+
+With "project_key" and "project_roles", the group of the role is made a member
+of the JFrog project with those project roles, and tokens are scoped to them.
+Such roles don't need permission targets. Project role names can't contain
+spaces, as they are part of the token scope.
+
+With "mode" set to "user", the permission targets are granted to a service user
+of the role instead of a group, and tokens are issued for that user. The mode
+is set when the role is created and can't be changed afterwards.
 `
 
 const pathListRoleHelpSyn = `List existing roles.`
+const pathListRoleHelpDesc = `
+This path lists the roles. They can be filtered by "label" (roles with all of
+the given labels), "repository" and "operation". With both "repository" and
+"operation", only roles granting the operation on that repository are listed.
+Roles on pseudo repositories such as "ANY LOCAL" are listed for any repository.
+
+With "detailed" set, the TTLs, repositories, operations, owner, labels and the
+last Artifactory sync status of each role are returned as "key_info".
+`
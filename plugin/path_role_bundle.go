@@ -0,0 +1,508 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	rolesExportPath   = "roles-export"
+	rolesImportPath   = "roles-import"
+	roleBundleVersion = 1
+
+	conflictPolicySkip      = "skip"
+	conflictPolicyOverwrite = "overwrite"
+	conflictPolicyFail      = "fail"
+)
+
+// role names accepted by the roles/<name> path
+var roleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+// roleBundle is the versioned format used to export and import roles
+type roleBundle struct {
+	Version    int               `json:"version"`
+	ExportedAt string            `json:"exported_at,omitempty"`
+	Roles      []roleBundleEntry `json:"roles"`
+	// the role templates the roles are rendered from, imported before the roles
+	Templates []RoleTemplateEntry `json:"templates,omitempty"`
+}
+
+// roleBundleEntry holds the user supplied fields of a role, as accepted by roles/<name>
+type roleBundleEntry struct {
+	Name              string `json:"name"`
+	TokenTTL          int64  `json:"token_ttl"`
+	MaxTTL            int64  `json:"max_ttl"`
+	PermissionTargets string `json:"permission_targets,omitempty"`
+	DockerRegistry    string `json:"docker_registry,omitempty"`
+	ReferenceToken    bool   `json:"reference_token,omitempty"`
+	Mode              string `json:"mode,omitempty"`
+
+	ProjectKey   string   `json:"project_key,omitempty"`
+	ProjectRoles []string `json:"project_roles,omitempty"`
+
+	// roles rendered from a role template carry the template instead of permission targets
+	Template string            `json:"template,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func newRoleBundleEntry(role *RoleStorageEntry) roleBundleEntry {
+	e := roleBundleEntry{
+		Name:              role.Name,
+		TokenTTL:          int64(role.TokenTTL / time.Second),
+		MaxTTL:            int64(role.MaxTTL / time.Second),
+		PermissionTargets: role.RawPermissionTargets,
+		DockerRegistry:    role.DockerRegistry,
+		ReferenceToken:    role.ReferenceToken,
+		Mode:              role.Mode,
+		ProjectKey:        role.ProjectKey,
+		ProjectRoles:      role.ProjectRoles,
+		Description:       role.Description,
+		Owner:             role.Owner,
+		Labels:            role.Labels,
+		Metadata:          role.Metadata,
+	}
+	if role.Template != "" {
+		e.PermissionTargets = ""
+		e.Template = role.Template
+		if len(role.TemplateParams) > 0 {
+			e.Params = role.TemplateParams
+		}
+	}
+	return e
+}
+
+// update returns the bundled role as an update of the stored role
+func (e roleBundleEntry) update() roleUpdate {
+	registry, reference, description, owner := e.DockerRegistry, e.ReferenceToken, e.Description, e.Owner
+	mode, projectKey := e.Mode, e.ProjectKey
+	update := roleUpdate{
+		tokenTTL:       time.Duration(e.TokenTTL) * time.Second,
+		maxTTL:         time.Duration(e.MaxTTL) * time.Second,
+		dockerRegistry: &registry,
+		referenceToken: &reference,
+		mode:           &mode,
+		projectKey:     &projectKey,
+		projectRoles:   e.ProjectRoles,
+		description:    &description,
+		owner:          &owner,
+		labels:         e.Labels,
+		metadata:       e.Metadata,
+	}
+	// omitted project roles, labels and metadata are cleared, like the other fields
+	if update.projectRoles == nil {
+		update.projectRoles = []string{}
+	}
+	if update.labels == nil {
+		update.labels = map[string]string{}
+	}
+	if update.metadata == nil {
+		update.metadata = map[string]string{}
+	}
+	if e.Template != "" {
+		template := e.Template
+		update.template = &template
+		update.templateParams = e.Params
+		if update.templateParams == nil {
+			update.templateParams = map[string]string{}
+		}
+	} else {
+		pts := e.PermissionTargets
+		update.permissionTargets = &pts
+	}
+	return update
+}
+
+// validate checks a bundled role the same way roles/<name> would, without touching storage or Artifactory
+func (e roleBundleEntry) validate(config *ConfigStorageEntry) error {
+	if !roleNameRegex.MatchString(e.Name) {
+		return fmt.Errorf("invalid role name '%s'", e.Name)
+	}
+	switch {
+	case e.Template != "" && e.PermissionTargets != "":
+		return errors.New("permission targets and template are mutually exclusive")
+	case e.Template != "":
+		// rendered when the role is applied
+	case e.PermissionTargets == "" && e.ProjectKey == "":
+		return errors.New("permission targets are empty")
+	case e.PermissionTargets == "":
+		// project roles only
+	default:
+		if _, err := parsePermissionTargets(e.PermissionTargets); err != nil {
+			return err
+		}
+	}
+
+	if err := validateProject(e.ProjectKey, e.ProjectRoles); err != nil {
+		return err
+	}
+	if err := validateMode(e.Mode, e.ProjectKey); err != nil {
+		return err
+	}
+	if err := validateLabels(e.Labels); err != nil {
+		return err
+	}
+
+	e = e.withDefaults()
+	role := RoleStorageEntry{
+		TokenTTL: time.Duration(e.TokenTTL) * time.Second,
+		MaxTTL:   time.Duration(e.MaxTTL) * time.Second,
+	}
+	return role.validateTTLs(config)
+}
+
+// withDefaults returns the entry with unset TTLs replaced by the role defaults
+func (e roleBundleEntry) withDefaults() roleBundleEntry {
+	if e.MaxTTL <= 0 {
+		e.MaxTTL = int64(createRoleSchema["max_ttl"].Default.(int))
+	}
+	if e.TokenTTL <= 0 {
+		e.TokenTTL = int64(createRoleSchema["token_ttl"].Default.(int))
+	}
+	if len(e.Params) == 0 {
+		e.Params = nil
+	}
+	if len(e.ProjectRoles) == 0 {
+		e.ProjectRoles = nil
+	}
+	if len(e.Labels) == 0 {
+		e.Labels = nil
+	}
+	if len(e.Metadata) == 0 {
+		e.Metadata = nil
+	}
+	return e
+}
+
+// parseRoleBundle decodes a bundle and rejects unsupported versions and duplicate roles
+func parseRoleBundle(raw string) (*roleBundle, error) {
+	var bundle roleBundle
+	if err := json.Unmarshal([]byte(raw), &bundle); err != nil {
+		return nil, fmt.Errorf("unable to decode bundle - %s", err.Error())
+	}
+	if bundle.Version != roleBundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d, expecting %d", bundle.Version, roleBundleVersion)
+	}
+
+	seen := map[string]bool{}
+	for _, e := range bundle.Roles {
+		if seen[e.Name] {
+			return nil, fmt.Errorf("role '%s' is defined more than once", e.Name)
+		}
+		seen[e.Name] = true
+	}
+	seen = map[string]bool{}
+	for _, tmpl := range bundle.Templates {
+		if seen[tmpl.Name] {
+			return nil, fmt.Errorf("role template '%s' is defined more than once", tmpl.Name)
+		}
+		seen[tmpl.Name] = true
+	}
+	return &bundle, nil
+}
+
+func (backend *ArtifactoryBackend) pathRolesExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleNames, err := backend.listRoleEntries(ctx, req.Storage)
+	if err != nil {
+		return logical.ErrorResponse("Error listing roles"), err
+	}
+
+	bundle := roleBundle{
+		Version:    roleBundleVersion,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Roles:      []roleBundleEntry{},
+	}
+	templateNames, exported := []string{}, map[string]bool{}
+	for _, roleName := range roleNames {
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Error reading role %s", roleName)), err
+		}
+		if role == nil {
+			continue
+		}
+		bundle.Roles = append(bundle.Roles, newRoleBundleEntry(role))
+
+		// the templates of the roles are bundled, so the roles can be imported where they don't exist
+		if role.Template == "" || exported[role.Template] {
+			continue
+		}
+		tmpl, err := getRoleTemplateEntry(ctx, req.Storage, role.Template)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Error reading role template %s", role.Template)), err
+		}
+		if tmpl == nil {
+			return logical.ErrorResponse(fmt.Sprintf("role '%s' uses role template '%s' which does not exist", roleName, role.Template)), nil
+		}
+		bundle.Templates = append(bundle.Templates, *tmpl)
+		templateNames = append(templateNames, role.Template)
+		exported[role.Template] = true
+	}
+
+	raw, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":   bundle.Version,
+			"roles":     roleNames,
+			"templates": templateNames,
+			"bundle":    string(raw),
+		},
+	}, nil
+}
+
+func (backend *ArtifactoryBackend) pathRolesImport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawBundle := data.Get("bundle").(string)
+	if rawBundle == "" {
+		return logical.ErrorResponse("bundle not supplied"), nil
+	}
+
+	conflictPolicy := data.Get("conflict_policy").(string)
+	switch conflictPolicy {
+	case conflictPolicySkip, conflictPolicyOverwrite, conflictPolicyFail:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Unknown conflict policy '%s', expecting one of %v",
+			conflictPolicy, []string{conflictPolicySkip, conflictPolicyOverwrite, conflictPolicyFail})), nil
+	}
+	dryRun := data.Get("dry_run").(bool)
+
+	bundle, err := parseRoleBundle(rawBundle)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+
+	// validate the whole bundle before changing anything
+	var merr *multierror.Error
+	bundled := map[string]bool{}
+	for _, tmpl := range bundle.Templates {
+		bundled[tmpl.Name] = true
+		if !roleNameRegex.MatchString(tmpl.Name) {
+			merr = multierror.Append(merr, fmt.Errorf("invalid role template name '%s'", tmpl.Name))
+		} else if err := tmpl.validate(); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("role template '%s': %s", tmpl.Name, err.Error()))
+		}
+	}
+	for _, e := range bundle.Roles {
+		if err := e.validate(config); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("role '%s': %s", e.Name, err.Error()))
+			continue
+		}
+		if e.Template == "" || bundled[e.Template] {
+			continue
+		}
+		tmpl, err := getRoleTemplateEntry(ctx, req.Storage, e.Template)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl == nil {
+			merr = multierror.Append(merr, fmt.Errorf("role '%s': role template '%s' is neither in the bundle nor in the mount", e.Name, e.Template))
+		}
+	}
+	if err := merr.ErrorOrNil(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	createdTemplates, updatedTemplates, skippedTemplates, templateConflicts := []string{}, []string{}, []string{}, []string{}
+	templatesToSave := []RoleTemplateEntry{}
+	for _, tmpl := range bundle.Templates {
+		existing, err := getRoleTemplateEntry(ctx, req.Storage, tmpl.Name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case existing == nil:
+			templatesToSave = append(templatesToSave, tmpl)
+			createdTemplates = append(createdTemplates, tmpl.Name)
+		case existing.PermissionTargets == tmpl.PermissionTargets, conflictPolicy == conflictPolicySkip:
+			skippedTemplates = append(skippedTemplates, tmpl.Name)
+		case conflictPolicy == conflictPolicyOverwrite:
+			templatesToSave = append(templatesToSave, tmpl)
+			updatedTemplates = append(updatedTemplates, tmpl.Name)
+		default:
+			templateConflicts = append(templateConflicts, tmpl.Name)
+		}
+	}
+	if len(templateConflicts) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("role templates already exist with other permission targets: %s", strings.Join(templateConflicts, ", "))), nil
+	}
+
+	created, updated, skipped, conflicts := []string{}, []string{}, []string{}, []string{}
+	toApply := []roleBundleEntry{}
+	existing := map[string]bool{}
+	for _, e := range bundle.Roles {
+		role, err := getRoleEntry(ctx, req.Storage, e.Name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			toApply = append(toApply, e)
+			continue
+		}
+
+		existing[e.Name] = true
+		switch conflictPolicy {
+		case conflictPolicySkip:
+			skipped = append(skipped, e.Name)
+		case conflictPolicyOverwrite:
+			toApply = append(toApply, e)
+		case conflictPolicyFail:
+			conflicts = append(conflicts, e.Name)
+		}
+	}
+	if len(conflicts) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("roles already exist: %s", strings.Join(conflicts, ", "))), nil
+	}
+
+	// roles are rendered from the templates, which are saved first
+	if !dryRun {
+		for _, tmpl := range templatesToSave {
+			if err := tmpl.save(ctx, req.Storage); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	failed := map[string]string{}
+	var warnings []string
+	for _, e := range toApply {
+		if !dryRun {
+			_, roleWarnings, err := backend.applyRole(ctx, req, config, e.Name, e.update(), !existing[e.Name])
+			if err != nil {
+				backend.Logger().Warn("unable to import role", "role_name", e.Name, "error", err)
+				failed[e.Name] = err.Error()
+				continue
+			}
+			for _, w := range roleWarnings {
+				warnings = append(warnings, fmt.Sprintf("role '%s': %s", e.Name, w))
+			}
+		}
+
+		if existing[e.Name] {
+			updated = append(updated, e.Name)
+		} else {
+			created = append(created, e.Name)
+		}
+	}
+
+	respData := map[string]interface{}{
+		"dry_run": dryRun,
+		"created": created,
+		"updated": updated,
+		"skipped": skipped,
+
+		"created_templates": createdTemplates,
+		"updated_templates": updatedTemplates,
+		"skipped_templates": skippedTemplates,
+	}
+	if len(failed) > 0 {
+		respData["failed"] = failed
+	}
+
+	return &logical.Response{Data: respData, Warnings: warnings}, nil
+}
+
+func pathRoleBundle(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: rolesExportPath,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathRolesExport,
+			},
+			HelpSynopsis:    pathRolesExportHelpSyn,
+			HelpDescription: pathRolesExportHelpDesc,
+		},
+		{
+			Pattern: rolesImportPath,
+			Fields: map[string]*framework.FieldSchema{
+				"bundle": {
+					Type:        framework.TypeString,
+					Description: "Role bundle JSON, as returned by roles-export",
+				},
+				"conflict_policy": {
+					Type:        framework.TypeString,
+					Description: "What to do with roles that already exist. One of skip, overwrite or fail. Default fail",
+					Default:     conflictPolicyFail,
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Validate the bundle and report the changes without applying them",
+					Default:     false,
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathRolesImport,
+			},
+			HelpSynopsis:    pathRolesImportHelpSyn,
+			HelpDescription: pathRolesImportHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathRolesExportHelpSyn = `Export all roles as a versioned bundle.`
+const pathRolesExportHelpDesc = `
+This path returns every role of the mount in the "bundle" field, a JSON document
+which can be imported into another mount or Vault cluster with roles-import.
+Only the fields accepted by roles/<name> are exported, Artifactory objects are
+recreated by the import. Roles rendered from a role template are exported with
+their template and parameters, and the templates they use are exported along.
+`
+
+const pathRolesImportHelpSyn = `Import roles from a bundle created by roles-export.`
+const pathRolesImportHelpDesc = `
+This path creates or updates the roles of a bundle created by roles-export.
+Each role goes through the same validation and Artifactory sync as roles/<name>.
+The whole bundle is validated before any role is changed. The role templates of
+the bundle are imported before the roles, and roles rendered from a template
+which is neither in the bundle nor in the mount are rejected.
+
+"conflict_policy" decides what happens to roles that already exist:
+
+  skip       leave the existing role untouched
+  overwrite  replace the existing role with the bundled one
+  fail       reject the import without changing anything (default)
+
+It applies to role templates with other permission targets as well.
+
+With "dry_run" set, the bundle is validated and the roles which would be
+created, updated and skipped are returned without changing anything.
+`
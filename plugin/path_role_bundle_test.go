@@ -0,0 +1,388 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBundlePt = `[{"repo": {"repositories": ["libs-local"], "operations": ["read"]}}]`
+
+func TestPathRolesExportImport(t *testing.T) {
+	t.Parallel()
+
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	}
+
+	// source mount with two roles
+	srcReq, src := newArtMockEnv(t)
+	testConfigUpdate(t, src, srcReq.Storage, conf)
+	mustRoleCreate(srcReq, src, t, "role_a", map[string]interface{}{
+		"permission_targets": testBundlePt,
+		"token_ttl":          "600s",
+		"docker_registry":    "docker.example.jfrog.io",
+		"owner":              "platform",
+		"reference_token":    true,
+		"labels":             map[string]interface{}{"team": "platform"},
+	})
+	mustRoleCreate(srcReq, src, t, "role_b", map[string]interface{}{
+		"permission_targets": testBundlePt,
+		"mode":               "user",
+	})
+
+	resp, err := testRolesExport(srcReq, src, t)
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	assert.Equal(t, roleBundleVersion, resp.Data["version"])
+	assert.Equal(t, []string{"role_a", "role_b"}, resp.Data["roles"])
+	bundle := resp.Data["bundle"].(string)
+
+	t.Run("import", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		resp, err := testRolesImport(req, b, t, map[string]interface{}{"bundle": bundle})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, []string{"role_a", "role_b"}, resp.Data["created"])
+		assert.NotContains(t, resp.Data, "failed")
+
+		role, err := getRoleEntry(context.Background(), req.Storage, "role_a")
+		require.NoError(t, err)
+		require.NotNil(t, role)
+		assert.Equal(t, roleID("role_a"), role.RoleID)
+		assert.Equal(t, 600*time.Second, role.TokenTTL)
+		assert.Equal(t, "docker.example.jfrog.io", role.DockerRegistry)
+		assert.Equal(t, "platform", role.Owner)
+		assert.True(t, role.ReferenceToken)
+		assert.Equal(t, map[string]string{"team": "platform"}, role.Labels)
+		assert.Equal(t, testBundlePt, role.RawPermissionTargets)
+		assert.Len(t, role.PermissionTargets, 1)
+		assert.False(t, role.usesServiceUser())
+
+		role, err = getRoleEntry(context.Background(), req.Storage, "role_b")
+		require.NoError(t, err)
+		require.NotNil(t, role)
+		assert.True(t, role.usesServiceUser())
+	})
+
+	t.Run("dry_run", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		resp, err := testRolesImport(req, b, t, map[string]interface{}{"bundle": bundle, "dry_run": true})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, true, resp.Data["dry_run"])
+		assert.Equal(t, []string{"role_a", "role_b"}, resp.Data["created"])
+
+		roles, err := req.Storage.List(context.Background(), rolesPrefix+"/")
+		require.NoError(t, err)
+		assert.Empty(t, roles, "dry run must not store roles")
+	})
+
+	t.Run("conflict_policies", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			policy  string
+			err     string
+			updated []string
+			skipped []string
+			ttl     time.Duration
+		}{
+			{policy: conflictPolicyFail, err: "roles already exist: role_a", ttl: 900 * time.Second},
+			{policy: conflictPolicySkip, updated: []string{}, skipped: []string{"role_a"}, ttl: 900 * time.Second},
+			{policy: conflictPolicyOverwrite, updated: []string{"role_a"}, skipped: []string{}, ttl: 600 * time.Second},
+		}
+
+		for _, test := range tests {
+			test := test // capture range var
+			t.Run(test.policy, func(t *testing.T) {
+				t.Parallel()
+				req, b := newArtMockEnv(t)
+				testConfigUpdate(t, b, req.Storage, conf)
+				mustRoleCreate(req, b, t, "role_a", map[string]interface{}{
+					"permission_targets": testBundlePt,
+				})
+
+				resp, err := testRolesImport(req, b, t, map[string]interface{}{
+					"bundle":          bundle,
+					"conflict_policy": test.policy,
+				})
+				require.NoError(t, err)
+				if test.err != "" {
+					require.True(t, resp.IsError(), "expecting error")
+					assert.Equal(t, test.err, resp.Data["error"])
+
+					// nothing is imported when a conflict fails the import
+					role, err := getRoleEntry(context.Background(), req.Storage, "role_b")
+					require.NoError(t, err)
+					assert.Nil(t, role)
+				} else {
+					require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+					assert.Equal(t, []string{"role_b"}, resp.Data["created"])
+					assert.Equal(t, test.updated, resp.Data["updated"])
+					assert.Equal(t, test.skipped, resp.Data["skipped"])
+				}
+
+				role, err := getRoleEntry(context.Background(), req.Storage, "role_a")
+				require.NoError(t, err)
+				assert.Equal(t, test.ttl, role.TokenTTL)
+			})
+		}
+	})
+}
+
+func TestPathRolesExportImportTemplates(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	}
+	setup := func(t *testing.T) (*logical.Request, logical.Backend) {
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+		return req, b
+	}
+
+	// source mount with two roles rendered from one template
+	srcReq, src := setup(t)
+	resp, err := testRoleTemplateWrite(srcReq, src, t, "team-read", logical.CreateOperation, map[string]interface{}{
+		"permission_targets": testTemplatePt,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	for _, team := range []string{"team-x", "team-y"} {
+		mustRoleCreate(srcReq, src, t, team, map[string]interface{}{
+			"template": "team-read",
+			"params":   map[string]interface{}{"team": team},
+		})
+	}
+
+	resp, err = testRolesExport(srcReq, src, t)
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, []string{"team-read"}, resp.Data["templates"])
+	bundle := resp.Data["bundle"].(string)
+
+	t.Run("import", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+
+		resp, err := testRolesImport(req, b, t, map[string]interface{}{"bundle": bundle})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, []string{"team-read"}, resp.Data["created_templates"])
+		assert.Equal(t, []string{"team-x", "team-y"}, resp.Data["created"])
+		assert.NotContains(t, resp.Data, "failed")
+
+		tmpl, err := getRoleTemplateEntry(ctx, req.Storage, "team-read")
+		require.NoError(t, err)
+		require.NotNil(t, tmpl)
+		assert.Equal(t, testTemplatePt, tmpl.PermissionTargets)
+		role, err := getRoleEntry(ctx, req.Storage, "team-y")
+		require.NoError(t, err)
+		require.NotNil(t, role)
+		assert.Equal(t, "team-read", role.Template)
+		require.Len(t, role.PermissionTargets, 1)
+		assert.Equal(t, []string{"/team-y/**"}, role.PermissionTargets[0].Repo.IncludePatterns)
+	})
+
+	t.Run("template_conflict", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+		resp, err := testRoleTemplateWrite(req, b, t, "team-read", logical.CreateOperation, map[string]interface{}{
+			"permission_targets": `[{"repo": {"include_patterns": ["/{{team}}/**"], "repositories": ["libs-release"], "operations": ["read"]}}]`,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+		resp, err = testRolesImport(req, b, t, map[string]interface{}{"bundle": bundle})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "role templates already exist with other permission targets: team-read")
+
+		resp, err = testRolesImport(req, b, t, map[string]interface{}{"bundle": bundle, "conflict_policy": conflictPolicyOverwrite})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, []string{"team-read"}, resp.Data["updated_templates"])
+		role, err := getRoleEntry(ctx, req.Storage, "team-x")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"libs-local"}, role.PermissionTargets[0].Repo.Repositories)
+	})
+
+	t.Run("missing_template", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+
+		var withoutTemplates roleBundle
+		require.NoError(t, json.Unmarshal([]byte(bundle), &withoutTemplates))
+		withoutTemplates.Templates = nil
+		raw, err := json.Marshal(withoutTemplates)
+		require.NoError(t, err)
+
+		resp, err := testRolesImport(req, b, t, map[string]interface{}{"bundle": string(raw)})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "role 'team-x': role template 'team-read' is neither in the bundle nor in the mount")
+
+		roles, err := req.Storage.List(ctx, rolesPrefix+"/")
+		require.NoError(t, err)
+		assert.Empty(t, roles)
+	})
+
+	t.Run("export_without_template", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+		require.NoError(t, req.Storage.Put(ctx, &logical.StorageEntry{
+			Key:   rolesPrefix + "/orphan",
+			Value: []byte(`{"name": "orphan", "role_id": "0123456789abcdef", "template": "gone", "raw_permission_targets": "[]"}`),
+		}))
+
+		resp, err := testRolesExport(req, b, t)
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "role 'orphan' uses role template 'gone' which does not exist")
+	})
+}
+
+func TestPathRolesImportFail(t *testing.T) {
+	t.Parallel()
+
+	req, b := newArtMockEnv(t)
+	testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	mustBundle := func(roles ...roleBundleEntry) string {
+		raw, err := json.Marshal(roleBundle{Version: roleBundleVersion, Roles: roles})
+		require.NoError(t, err)
+		return string(raw)
+	}
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		err  string
+	}{
+		{
+			name: "missing_bundle",
+			data: map[string]interface{}{},
+			err:  "bundle not supplied",
+		},
+		{
+			name: "unknown_conflict_policy",
+			data: map[string]interface{}{"bundle": mustBundle(), "conflict_policy": "merge"},
+			err:  "Unknown conflict policy 'merge'",
+		},
+		{
+			name: "unsupported_version",
+			data: map[string]interface{}{"bundle": `{"version": 2, "roles": []}`},
+			err:  "unsupported bundle version 2, expecting 1",
+		},
+		{
+			name: "duplicate_role",
+			data: map[string]interface{}{"bundle": mustBundle(
+				roleBundleEntry{Name: "dup", PermissionTargets: testBundlePt},
+				roleBundleEntry{Name: "dup", PermissionTargets: testBundlePt},
+			)},
+			err: "role 'dup' is defined more than once",
+		},
+		{
+			name: "invalid_name",
+			data: map[string]interface{}{"bundle": mustBundle(
+				roleBundleEntry{Name: "bad/name", PermissionTargets: testBundlePt},
+			)},
+			err: "invalid role name 'bad/name'",
+		},
+		{
+			name: "invalid_permission_targets",
+			data: map[string]interface{}{"bundle": mustBundle(
+				roleBundleEntry{Name: "good", PermissionTargets: testBundlePt},
+				roleBundleEntry{Name: "bad", PermissionTargets: `[{"repo": {"operations": ["read"]}}]`},
+			)},
+			err: "'repo.repositories' field must be supplied",
+		},
+		{
+			name: "project_key_without_roles",
+			data: map[string]interface{}{"bundle": mustBundle(
+				roleBundleEntry{Name: "ml", ProjectKey: "ml"},
+			)},
+			err: "project_key needs project_roles",
+		},
+		{
+			name: "user_mode_with_project",
+			data: map[string]interface{}{"bundle": mustBundle(
+				roleBundleEntry{Name: "ml", Mode: "user", ProjectKey: "ml", ProjectRoles: []string{"Developer"}},
+			)},
+			err: "project roles are only supported in group mode",
+		},
+		{
+			name: "exceed_config_max_ttl",
+			data: map[string]interface{}{"bundle": mustBundle(
+				roleBundleEntry{Name: "long", PermissionTargets: testBundlePt, MaxTTL: 7200},
+			)},
+			err: "role max ttl is greater than config max ttl",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := testRolesImport(req, b, t, test.data)
+			require.NoError(t, err)
+			require.True(t, resp.IsError(), "expecting error")
+			assert.Contains(t, resp.Data["error"], test.err)
+		})
+	}
+
+	// a failed validation must not import the valid roles of the bundle
+	role, err := getRoleEntry(context.Background(), req.Storage, "good")
+	require.NoError(t, err)
+	assert.Nil(t, role)
+}
+
+func testRolesExport(req *logical.Request, b logical.Backend, t *testing.T) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.ReadOperation
+	req.Path = rolesExportPath
+	req.Data = nil
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func testRolesImport(req *logical.Request, b logical.Backend, t *testing.T, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.UpdateOperation
+	req.Path = rolesImportPath
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
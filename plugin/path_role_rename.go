@@ -0,0 +1,244 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var roleCopySchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the existing role",
+	},
+	"new_name": {
+		Type:        framework.TypeString,
+		Description: "The name of the new role",
+	},
+}
+
+// lockRoles write locks the roles until the returned func is called. The locks are taken in
+// a fixed order, so concurrent copies between the same roles can't deadlock.
+func (backend *ArtifactoryBackend) lockRoles(roleNames ...string) func() {
+	locks := locksutil.LocksForKeys(backend.roleLocks, roleNames)
+	for _, lock := range locks {
+		lock.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+// roleCopyNames returns the source and target role names of a rename or clone request
+func roleCopyNames(ctx context.Context, req *logical.Request, data *framework.FieldData) (*RoleStorageEntry, string, *logical.Response, error) {
+	roleName := data.Get("name").(string)
+	newName := data.Get("new_name").(string)
+	if newName == "" {
+		return nil, "", logical.ErrorResponse("new_name not supplied"), nil
+	}
+	if !roleNameRegex.MatchString(newName) {
+		return nil, "", logical.ErrorResponse(fmt.Sprintf("invalid role name '%s'", newName)), nil
+	}
+
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, "", logical.ErrorResponse("Error reading role"), err
+	}
+	if role == nil {
+		return nil, "", logical.ErrorResponse(fmt.Sprintf("Role name '%s' not recognised", roleName)), nil
+	}
+
+	existing, err := getRoleEntry(ctx, req.Storage, newName)
+	if err != nil {
+		return nil, "", logical.ErrorResponse("Error reading role"), err
+	}
+	if existing != nil {
+		return nil, "", logical.ErrorResponse(fmt.Sprintf("role '%s' already exists", newName)), nil
+	}
+	return role, newName, nil, nil
+}
+
+// rename the role. The role keeps its id and so its group, which keeps issued tokens valid,
+// while its permission targets are recreated under the new name.
+func (backend *ArtifactoryBackend) pathRoleRename(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	defer backend.lockRoles(data.Get("name").(string), data.Get("new_name").(string))()
+
+	role, newName, resp, err := roleCopyNames(ctx, req, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	renamed := *role
+	renamed.Name = newName
+
+	// permission targets with identity templates only exist once resolved
+	templated := hasIdentityTemplates(role.PermissionTargets)
+	if !templated {
+		if err := backend.renamePermissionTargets(ctx, req, role, &renamed); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if err := renamed.save(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	if !templated {
+		// the status is stored once the renamed role is, see storeRoleSyncStatus
+		backend.storeRoleSyncStatus(ctx, req.Storage, renamed.Name, nil)
+	}
+	if err := backend.deleteRoleEntry(ctx, req.Storage, role.Name); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, roleSyncKey(role.Name)); err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	if !templated {
+		if cleanupErr := backend.deleteUnsharedPermissionTargets(ctx, req, role, &renamed, len(role.PermissionTargets)); cleanupErr != nil {
+			backend.Logger().Warn(
+				"unable to clean up permission targets of renamed role.",
+				"role_name", role.Name, "errors", cleanupErr)
+			warnings = append(warnings, cleanupErr.Error())
+		}
+	}
+	// identity roles are named after their role, they are created again under the new name
+	if cleanupErr := backend.collectIdentityRoles(ctx, req, role.Name, true); cleanupErr != nil {
+		backend.Logger().Warn(
+			"unable to clean up identity roles of renamed role.",
+			"role_name", role.Name, "errors", cleanupErr)
+		warnings = append(warnings, cleanupErr.Error())
+	}
+
+	backend.Logger().Debug("renamed role", "name", role.Name, "new_name", newName)
+	return &logical.Response{Data: roleDetails(&renamed), Warnings: warnings}, nil
+}
+
+// renamePermissionTargets creates the permission targets of the renamed role, bound to its
+// existing group. Already created permission targets are removed when one fails, unless the
+// role had them under the same name.
+func (backend *ArtifactoryBackend) renamePermissionTargets(ctx context.Context, req *logical.Request, role, renamed *RoleStorageEntry) (err error) {
+	defer func() { emitRoleSync(renamed.Name, err) }()
+
+	ac, err := backend.getClient(ctx, req.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+
+	for idx, pt := range renamed.PermissionTargets {
+		ptName := rolePermissionTargetName(renamed, idx)
+		backend.Logger().Debug("creating a permission target", "name", ptName)
+		if err := ac.CreateOrUpdatePermissionTarget(ctx, renamed, &pt, ptName); err != nil {
+			var merr *multierror.Error
+			merr = multierror.Append(merr, fmt.Errorf("Failed to create/update a permission target - %s", err.Error()))
+			if cleanupErr := backend.deleteUnsharedPermissionTargets(ctx, req, renamed, role, idx); cleanupErr != nil {
+				merr = multierror.Append(merr, cleanupErr)
+			}
+			return merr.ErrorOrNil()
+		}
+	}
+	return nil
+}
+
+// deleteUnsharedPermissionTargets removes the first count permission targets of the role, except
+// those named the same for other, e.g. when the naming doesn't use the role name
+func (backend *ArtifactoryBackend) deleteUnsharedPermissionTargets(ctx context.Context, req *logical.Request, role, other *RoleStorageEntry, count int) error {
+	for idx := 0; idx < count; idx++ {
+		if idx < len(other.PermissionTargets) && rolePermissionTargetName(role, idx) == rolePermissionTargetName(other, idx) {
+			continue
+		}
+		if err := backend.tryDeleteRoleResources(ctx, req, role, role.PermissionTargets[idx:idx+1], idx, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clone the role into an independent role with its own group and permission targets
+func (backend *ArtifactoryBackend) pathRoleClone(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	defer backend.lockRoles(data.Get("name").(string), data.Get("new_name").(string))()
+
+	role, newName, resp, err := roleCopyNames(ctx, req, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+
+	clone, warnings, err := backend.applyRoleLocked(ctx, req, config, newName, newRoleBundleEntry(role).update(), true)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	backend.Logger().Debug("cloned role", "name", role.Name, "new_name", newName)
+	return &logical.Response{Data: roleDetails(clone), Warnings: warnings}, nil
+}
+
+func pathRoleCopy(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s/rename", rolesPrefix, framework.GenericNameRegex("name")),
+			Fields:  roleCopySchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathRoleRename,
+			},
+			HelpSynopsis:    pathRoleRenameHelpSyn,
+			HelpDescription: pathRoleRenameHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/clone", rolesPrefix, framework.GenericNameRegex("name")),
+			Fields:  roleCopySchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathRoleClone,
+			},
+			HelpSynopsis:    pathRoleCloneHelpSyn,
+			HelpDescription: pathRoleCloneHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathRoleRenameHelpSyn = `Rename a role.`
+const pathRoleRenameHelpDesc = `
+This path renames a role to "new_name", which must not exist yet.
+
+The role keeps its id and its Artifactory group, so tokens issued before the
+rename stay valid. Permission targets are named after the role, they are
+created under the new name and the old ones are removed. The old name may be
+reused by a new role, which gets a group of its own.
+`
+
+const pathRoleCloneHelpSyn = `Clone a role.`
+const pathRoleCloneHelpDesc = `
+This path creates "new_name" as a copy of a role, which must not exist yet.
+
+The clone is independent of the original role: it gets its own id, group and
+permission targets, and changes to either role don't affect the other.
+`
@@ -0,0 +1,261 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRoleRename(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, _, client := newIdentityMockEnv(t)
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testBundlePt,
+	})
+	original, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+
+	resp, err := testRoleCopy(req, backend, t, "ci", "rename", "ci-renamed")
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+	renamed, err := getRoleEntry(ctx, req.Storage, "ci-renamed")
+	require.NoError(t, err)
+	require.NotNil(t, renamed)
+	assert.Equal(t, original.RoleID, renamed.RoleID, "renamed role must keep its group")
+	status, err := getRoleSyncStatus(ctx, req.Storage, "ci-renamed")
+	require.NoError(t, err)
+	require.NotNil(t, status, "sync status of the renamed role")
+	assert.True(t, status.Success)
+
+	old, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	assert.Nil(t, old)
+
+	assert.Equal(t, map[string]bool{groupName(original): true}, client.groups)
+	assert.Contains(t, client.pts, permissionTargetName("ci-renamed", 0))
+	assert.NotContains(t, client.pts, permissionTargetName("ci", 0))
+
+	// the old name is free again, but not its group
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testBundlePt,
+	})
+	reused, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	assert.NotEqual(t, renamed.RoleID, reused.RoleID)
+	groups, pts := client.counts()
+	assert.Equal(t, 2, groups)
+	assert.Equal(t, 2, pts)
+
+	mustRoleDelete(req, backend, t, "ci-renamed")
+	groups, pts = client.counts()
+	assert.Equal(t, 1, groups)
+	assert.Equal(t, 1, pts)
+	assert.True(t, client.groups[groupName(reused)])
+}
+
+func TestPathRoleRenameRoleIDNaming(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// permission targets named after the role id only keep their names when renamed
+	req, backend, _, client := newIdentityMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"permission_target_name_template": "{{prefix}}.{{role_id}}.pt{{index}}",
+	})
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": `[
+			{"repo": {"repositories": ["libs-local"], "operations": ["read"]}},
+			{"repo": {"repositories": ["libs-release"], "operations": ["read"]}}
+		]`,
+	})
+	original, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+
+	resp, err := testRoleCopy(req, backend, t, "ci", "rename", "ci-renamed")
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Empty(t, resp.Warnings)
+
+	renamed, err := getRoleEntry(ctx, req.Storage, "ci-renamed")
+	require.NoError(t, err)
+	require.NotNil(t, renamed)
+	groups, pts := client.state()
+	assert.Equal(t, []string{groupName(original)}, groups)
+	assert.Equal(t, map[string]string{
+		rolePermissionTargetName(renamed, 0): "libs-local",
+		rolePermissionTargetName(renamed, 1): "libs-release",
+	}, pts, "permission targets of the renamed role removed")
+	assert.Equal(t, rolePermissionTargetName(original, 0), rolePermissionTargetName(renamed, 0))
+}
+
+func TestPathRoleClone(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, _, client := newIdentityMockEnv(t)
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testBundlePt,
+		"token_ttl":          "10m",
+	})
+
+	resp, err := testRoleCopy(req, backend, t, "ci", "clone", "ci-clone")
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, "ci-clone", resp.Data["role_name"])
+
+	original, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	clone, err := getRoleEntry(ctx, req.Storage, "ci-clone")
+	require.NoError(t, err)
+	require.NotNil(t, clone)
+	assert.NotEqual(t, original.RoleID, clone.RoleID)
+	assert.Equal(t, original.PermissionTargets, clone.PermissionTargets)
+	assert.Equal(t, original.TokenTTL, clone.TokenTTL)
+
+	groups, pts := client.counts()
+	assert.Equal(t, 2, groups)
+	assert.Equal(t, 2, pts)
+}
+
+func TestPathRoleCopyConcurrent(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// of concurrent copies to the same new name, only one may store it
+	for _, op := range []string{"rename", "clone"} {
+		op := op // capture range var
+		t.Run(op, func(t *testing.T) {
+			t.Parallel()
+			for i := 0; i < 10; i++ {
+				req, backend, _, client := newIdentityMockEnv(t)
+				for _, roleName := range []string{"ci-a", "ci-b"} {
+					mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+						"permission_targets": testBundlePt,
+					})
+				}
+				client.slowDown(time.Millisecond)
+
+				var wg sync.WaitGroup
+				succeeded := make(chan string, 2)
+				for _, roleName := range []string{"ci-a", "ci-b"} {
+					wg.Add(1)
+					go func(roleName string) {
+						defer wg.Done()
+						r := &logical.Request{Storage: req.Storage}
+						resp, err := testRoleCopy(r, backend, t, roleName, op, "ci-new")
+						if err == nil && !resp.IsError() {
+							succeeded <- roleName
+						}
+					}(roleName)
+				}
+				wg.Wait()
+				close(succeeded)
+
+				winners := []string{}
+				for roleName := range succeeded {
+					winners = append(winners, roleName)
+				}
+				require.Len(t, winners, 1)
+				role, err := getRoleEntry(ctx, req.Storage, "ci-new")
+				require.NoError(t, err)
+				require.NotNil(t, role)
+				if op == "rename" {
+					assert.Equal(t, roleID(winners[0]), role.RoleID, "the renamed role was overwritten")
+				}
+			}
+		})
+	}
+}
+
+func TestPathRoleCopyErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		role    string
+		newName string
+		err     string
+	}{
+		{
+			name:    "missing_new_name",
+			role:    "ci",
+			newName: "",
+			err:     "new_name not supplied",
+		},
+		{
+			name:    "invalid_new_name",
+			role:    "ci",
+			newName: "ci/other",
+			err:     "invalid role name 'ci/other'",
+		},
+		{
+			name:    "unknown_role",
+			role:    "unknown",
+			newName: "other",
+			err:     "Role name 'unknown' not recognised",
+		},
+		{
+			name:    "existing_new_name",
+			role:    "ci",
+			newName: "existing",
+			err:     "role 'existing' already exists",
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		for _, op := range []string{"rename", "clone"} {
+			op := op // capture range var
+			t.Run(fmt.Sprintf("%s_%s", op, test.name), func(t *testing.T) {
+				t.Parallel()
+
+				req, backend, _, _ := newIdentityMockEnv(t)
+				mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+					"permission_targets": testBundlePt,
+				})
+				mustRoleCreate(req, backend, t, "existing", map[string]interface{}{
+					"permission_targets": testBundlePt,
+				})
+
+				resp, err := testRoleCopy(req, backend, t, test.role, op, test.newName)
+				require.NoError(t, err)
+				require.True(t, resp.IsError(), "expecting error")
+				assert.Contains(t, resp.Data["error"], test.err)
+			})
+		}
+	}
+}
+
+func testRoleCopy(req *logical.Request, b logical.Backend, t *testing.T, roleName, op, newName string) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.UpdateOperation
+	req.Path = fmt.Sprintf("roles/%s/%s", roleName, op)
+	req.Data = map[string]interface{}{
+		"new_name": newName,
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
@@ -0,0 +1,228 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var roleTemplateSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role template",
+	},
+	"permission_targets": {
+		Type:        framework.TypeString,
+		Description: "List of permission target configurations with {{param}} placeholders",
+	},
+	"update_roles": {
+		Type:        framework.TypeBool,
+		Description: "Render the roles created from this template again with the updated permission targets",
+		Default:     false,
+	},
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplateCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("Role template name not supplied"), nil
+	}
+
+	tmpl, err := getRoleTemplateEntry(ctx, req.Storage, name)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role template"), err
+	}
+	if tmpl == nil {
+		tmpl = &RoleTemplateEntry{Name: name}
+	}
+
+	if pts, ok := data.GetOk("permission_targets"); ok {
+		tmpl.PermissionTargets = pts.(string)
+	}
+	if tmpl.PermissionTargets == "" {
+		return logical.ErrorResponse("permission targets are required for role template"), nil
+	}
+	if err := tmpl.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := tmpl.save(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+
+	roles, err := backend.rolesUsingTemplate(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"name":   tmpl.Name,
+			"params": tmpl.params(),
+			"roles":  roles,
+		},
+	}
+	if !data.Get("update_roles").(bool) || len(roles) == 0 {
+		return resp, nil
+	}
+
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+
+	// re-render each role with its own parameters
+	updated := []string{}
+	for _, roleName := range roles {
+		_, warnings, err := backend.applyRole(ctx, req, config, roleName, roleUpdate{template: &name}, false)
+		if err != nil {
+			backend.Logger().Warn("unable to update role from template", "role_name", roleName, "template", name, "error", err)
+			resp.AddWarning(fmt.Sprintf("unable to update role '%s' - %s", roleName, err.Error()))
+			continue
+		}
+		for _, w := range warnings {
+			resp.AddWarning(fmt.Sprintf("role '%s': %s", roleName, w))
+		}
+		updated = append(updated, roleName)
+	}
+	resp.Data["updated_roles"] = updated
+
+	return resp, nil
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplateRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	tmpl, err := getRoleTemplateEntry(ctx, req.Storage, name)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role template"), err
+	}
+	if tmpl == nil {
+		return nil, nil
+	}
+
+	roles, err := backend.rolesUsingTemplate(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":               tmpl.Name,
+			"permission_targets": tmpl.PermissionTargets,
+			"params":             tmpl.params(),
+			"roles":              roles,
+		},
+	}, nil
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplateDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	roles, err := backend.rolesUsingTemplate(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("role template '%s' is used by roles: %s", name, strings.Join(roles, ", "))), nil
+	}
+
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", roleTemplatesPrefix, name)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplatesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	templates, err := req.Storage.List(ctx, fmt.Sprintf("%s/", roleTemplatesPrefix))
+	if err != nil {
+		return logical.ErrorResponse("Error listing role templates"), err
+	}
+	return logical.ListResponse(templates), nil
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplateExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	tmpl, err := getRoleTemplateEntry(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return tmpl != nil, nil
+}
+
+func pathRoleTemplate(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern:        fmt.Sprintf("%s/%s", roleTemplatesPrefix, framework.GenericNameRegex("name")),
+			Fields:         roleTemplateSchema,
+			ExistenceCheck: backend.pathRoleTemplateExistenceCheck,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.CreateOperation: backend.pathRoleTemplateCreateUpdate,
+				logical.UpdateOperation: backend.pathRoleTemplateCreateUpdate,
+				logical.ReadOperation:   backend.pathRoleTemplateRead,
+				logical.DeleteOperation: backend.pathRoleTemplateDelete,
+			},
+			HelpSynopsis:    pathRoleTemplateHelpSyn,
+			HelpDescription: pathRoleTemplateHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s?/?", roleTemplatesPrefix),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: backend.pathRoleTemplatesList,
+			},
+			HelpSynopsis: pathListRoleTemplateHelpSyn,
+		},
+	}
+
+	return paths
+}
+
+const pathRoleTemplateHelpSyn = `Read/write role templates which roles render their permission targets from.`
+const pathRoleTemplateHelpDesc = `
+This path allows you to create role templates, which hold permission targets
+with {{param}} placeholders, in the format accepted by roles/<name>:
+
+[
+  {
+    "repo": {
+      "include_patterns": ["/{{team}}/**"],
+      "repositories": ["libs-release-local"],
+      "operations": ["read", "write"]
+    }
+  }
+]
+
+Roles are created from a template with its parameters instead of permission
+targets:
+
+  vault write artifactory/roles/team-x template=team-publish params=team=team-x
+
+Parameter values are substituted as JSON string content and the rendered
+permission targets are validated like any other role. Placeholders with dots,
+such as identity templates, are not parameters and are kept as they are.
+
+Updating a template does not change existing roles unless "update_roles" is
+set, in which case every role created from the template is rendered again with
+its own parameters and synced with Artifactory. A template can't be deleted
+while roles use it.
+`
+
+const pathListRoleTemplateHelpSyn = `List existing role templates.`
@@ -0,0 +1,227 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTemplatePt = `[{"repo": {"include_patterns": ["/{{team}}/**"], "repositories": ["libs-local"], "operations": ["read"]}}]`
+
+func TestPathRoleTemplate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	setup := func(t *testing.T) (*logical.Request, logical.Backend) {
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+			"base_url":     "https://example.jfrog.io/example",
+			"bearer_token": "mybearertoken",
+		})
+		resp, err := testRoleTemplateWrite(req, b, t, "team-read", logical.CreateOperation, map[string]interface{}{
+			"permission_targets": testTemplatePt,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, []string{"team"}, resp.Data["params"])
+		return req, b
+	}
+
+	t.Run("create_role_from_template", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+
+		mustRoleCreate(req, b, t, "team-x", map[string]interface{}{
+			"template": "team-read",
+			"params":   map[string]interface{}{"team": "team-x"},
+		})
+
+		role, err := getRoleEntry(ctx, req.Storage, "team-x")
+		require.NoError(t, err)
+		assert.Equal(t, "team-read", role.Template)
+		assert.Equal(t, map[string]string{"team": "team-x"}, role.TemplateParams)
+		require.Len(t, role.PermissionTargets, 1)
+		assert.Equal(t, []string{"/team-x/**"}, role.PermissionTargets[0].Repo.IncludePatterns)
+
+		resp, err := testRoleTemplateRead(req, b, t, "team-read")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"team-x"}, resp.Data["roles"])
+
+		// explicit permission targets detach the role from its template
+		mustRoleUpdate(req, b, t, "team-x", map[string]interface{}{
+			"permission_targets": testBundlePt,
+		})
+		role, err = getRoleEntry(ctx, req.Storage, "team-x")
+		require.NoError(t, err)
+		assert.Empty(t, role.Template)
+		assert.Nil(t, role.TemplateParams)
+	})
+
+	t.Run("update_roles", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+
+		for _, team := range []string{"team-x", "team-y"} {
+			mustRoleCreate(req, b, t, team, map[string]interface{}{
+				"template": "team-read",
+				"params":   fmt.Sprintf("team=%s", team),
+			})
+		}
+
+		updatedPt := `[{"repo": {"include_patterns": ["/{{team}}/**"], "repositories": ["libs-local"], "operations": ["read", "write"]}}]`
+
+		// without update_roles the roles keep their permission targets
+		resp, err := testRoleTemplateWrite(req, b, t, "team-read", logical.UpdateOperation, map[string]interface{}{
+			"permission_targets": updatedPt,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.NotContains(t, resp.Data, "updated_roles")
+		role, err := getRoleEntry(ctx, req.Storage, "team-y")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"read"}, role.PermissionTargets[0].Repo.Operations)
+
+		resp, err = testRoleTemplateWrite(req, b, t, "team-read", logical.UpdateOperation, map[string]interface{}{
+			"update_roles": true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, []string{"team-x", "team-y"}, resp.Data["updated_roles"])
+
+		role, err = getRoleEntry(ctx, req.Storage, "team-y")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"read", "write"}, role.PermissionTargets[0].Repo.Operations)
+		assert.Equal(t, []string{"/team-y/**"}, role.PermissionTargets[0].Repo.IncludePatterns)
+
+		// a template in use can't be deleted
+		resp, err = testRoleTemplateDelete(req, b, t, "team-read")
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Equal(t, "role template 'team-read' is used by roles: team-x, team-y", resp.Data["error"])
+	})
+
+	t.Run("export_and_apply", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+		mustRoleCreate(req, b, t, "team-x", map[string]interface{}{
+			"template": "team-read",
+			"params":   "team=team-x",
+		})
+
+		resp, err := testRolesExport(req, b, t)
+		require.NoError(t, err)
+		bundle, err := parseRoleBundle(resp.Data["bundle"].(string))
+		require.NoError(t, err)
+		require.Len(t, bundle.Roles, 1)
+		assert.Equal(t, "team-read", bundle.Roles[0].Template)
+		assert.Equal(t, map[string]string{"team": "team-x"}, bundle.Roles[0].Params)
+		assert.Empty(t, bundle.Roles[0].PermissionTargets)
+
+		roles, err := json.Marshal(bundle.Roles)
+		require.NoError(t, err)
+		resp, err = testRolesApply(req, b, t, map[string]interface{}{"roles": string(roles)})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		result := resp.Data["roles"].(map[string]interface{})["team-x"].(map[string]interface{})
+		assert.Equal(t, applyActionUnchanged, result["action"])
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+
+		tests := []struct {
+			name string
+			path string
+			data map[string]interface{}
+			err  string
+		}{
+			{
+				name: "invalid_template",
+				path: "role-templates/bad",
+				data: map[string]interface{}{"permission_targets": `[{"repo": {"repositories": ["{{repo}}"], "operations": ["fly"]}}]`},
+				err:  "operation 'fly' is not allowed",
+			},
+			{
+				name: "missing_template",
+				path: "roles/role_a",
+				data: map[string]interface{}{"template": "nope", "params": "team=a"},
+				err:  "role template 'nope' does not exist",
+			},
+			{
+				name: "missing_param",
+				path: "roles/role_a",
+				data: map[string]interface{}{"template": "team-read"},
+				err:  "missing parameters for template 'team-read': team",
+			},
+			{
+				name: "template_and_permission_targets",
+				path: "roles/role_a",
+				data: map[string]interface{}{"template": "team-read", "params": "team=a", "permission_targets": testBundlePt},
+				err:  "permission targets and template are mutually exclusive",
+			},
+			{
+				name: "params_without_template",
+				path: "roles/role_a",
+				data: map[string]interface{}{"params": "team=a", "permission_targets": testBundlePt},
+				err:  "params are only supported with a template",
+			},
+		}
+
+		for _, test := range tests {
+			req.Operation = logical.CreateOperation
+			req.Path = test.path
+			req.Data = test.data
+			resp, err := b.HandleRequest(ctx, req)
+			require.NoError(t, err, test.name)
+			require.True(t, resp.IsError(), "expecting error for %s", test.name)
+			assert.Contains(t, resp.Data["error"], test.err, test.name)
+		}
+	})
+}
+
+func testRoleTemplateWrite(req *logical.Request, b logical.Backend, t *testing.T, name string, op logical.Operation, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = op
+	req.Path = fmt.Sprintf("%s/%s", roleTemplatesPrefix, name)
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func testRoleTemplateRead(req *logical.Request, b logical.Backend, t *testing.T, name string) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.ReadOperation
+	req.Path = fmt.Sprintf("%s/%s", roleTemplatesPrefix, name)
+	req.Data = nil
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func testRoleTemplateDelete(req *logical.Request, b logical.Backend, t *testing.T, name string) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.DeleteOperation
+	req.Path = fmt.Sprintf("%s/%s", roleTemplatesPrefix, name)
+	req.Data = nil
+
+	return b.HandleRequest(context.Background(), req)
+}
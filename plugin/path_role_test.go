@@ -367,6 +367,173 @@ func TestArtAccPermissionTargets(t *testing.T) {
 	})
 }
 
+func TestPathRoleMetadata(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, _, client := newIdentityMockEnv(t)
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testBundlePt,
+		"description":        "CI builds of the platform team",
+		"owner":              "platform",
+		"labels":             map[string]interface{}{"team": "platform", "env": "prod"},
+		"metadata":           map[string]interface{}{"ticket": "OPS-1"},
+	})
+	mustRoleCreate(req, backend, t, "dev", map[string]interface{}{
+		"permission_targets": testBundlePt,
+		"labels":             map[string]interface{}{"team": "platform", "env": "dev"},
+	})
+
+	role, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	assert.Equal(t, "CI builds of the platform team", client.descriptions[groupName(role)])
+
+	resp, err := testRoleRead(req, backend, t, "ci")
+	require.NoError(t, err)
+	assert.Equal(t, "CI builds of the platform team", resp.Data["description"])
+	assert.Equal(t, "platform", resp.Data["owner"])
+	assert.Equal(t, map[string]string{"team": "platform", "env": "prod"}, resp.Data["labels"])
+	assert.Equal(t, map[string]string{"ticket": "OPS-1"}, resp.Data["metadata"])
+
+	t.Run("description_updates_group", func(t *testing.T) {
+		mustRoleUpdate(req, backend, t, "ci", map[string]interface{}{
+			"description": "CI builds",
+		})
+		assert.Equal(t, "CI builds", client.descriptions[groupName(role)])
+
+		mustRoleUpdate(req, backend, t, "ci", map[string]interface{}{
+			"description": "",
+		})
+		assert.Equal(t, "vault plugin group for ci", client.descriptions[groupName(role)])
+	})
+
+	t.Run("list_by_label", func(t *testing.T) {
+		tests := []struct {
+			label    map[string]interface{}
+			expected []string
+		}{
+			{label: map[string]interface{}{"team": "platform"}, expected: []string{"ci", "dev"}},
+			{label: map[string]interface{}{"team": "platform", "env": "prod"}, expected: []string{"ci"}},
+			{label: map[string]interface{}{"team": "other"}, expected: []string{}},
+		}
+		for _, test := range tests {
+			resp, err := testRolesListFiltered(req, backend, t, map[string]interface{}{"label": test.label})
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+			assert.ElementsMatch(t, test.expected, resp.Data["keys"], "label %v", test.label)
+		}
+	})
+
+	t.Run("invalid_label", func(t *testing.T) {
+		resp, err := testRoleUpdate(req, backend, t, "ci", map[string]interface{}{
+			"labels": map[string]interface{}{"team name": "platform"},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "label key 'team name' is not allowed")
+	})
+}
+
+func TestPathRolesListFiltered(t *testing.T) {
+	t.Parallel()
+
+	req, backend, _, _ := newIdentityMockEnv(t)
+	mustRoleCreate(req, backend, t, "docker-publish", map[string]interface{}{
+		"permission_targets": `[{"repo": {"repositories": ["docker-prod-local"], "operations": ["read", "write"]}}]`,
+		"owner":              "platform",
+		"labels":             map[string]interface{}{"team": "platform"},
+	})
+	mustRoleCreate(req, backend, t, "docker-read", map[string]interface{}{
+		"permission_targets": `[{"repo": {"repositories": ["docker-prod-local"], "operations": ["read"]}}]`,
+	})
+	mustRoleCreate(req, backend, t, "any-local", map[string]interface{}{
+		"permission_targets": `[{"repo": {"repositories": ["ANY LOCAL"], "operations": ["write"]}}]`,
+	})
+	mustRoleCreate(req, backend, t, "builds", map[string]interface{}{
+		"permission_targets": `[{"build": {"repositories": ["artifactory-build-info"], "operations": ["manage"]}}]`,
+	})
+
+	tests := []struct {
+		name     string
+		data     map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "repository",
+			data:     map[string]interface{}{"repository": "docker-prod-local"},
+			expected: []string{"docker-publish", "docker-read", "any-local"},
+		},
+		{
+			name:     "repository_operation",
+			data:     map[string]interface{}{"repository": "docker-prod-local", "operation": "write"},
+			expected: []string{"docker-publish", "any-local"},
+		},
+		{
+			name:     "operation",
+			data:     map[string]interface{}{"operation": "manage"},
+			expected: []string{"builds"},
+		},
+		{
+			name:     "label_repository",
+			data:     map[string]interface{}{"repository": "docker-prod-local", "label": map[string]interface{}{"team": "platform"}},
+			expected: []string{"docker-publish"},
+		},
+		{
+			name:     "no_match",
+			data:     map[string]interface{}{"repository": "libs-release-local", "operation": "delete"},
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		resp, err := testRolesListFiltered(req, backend, t, test.data)
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.ElementsMatch(t, test.expected, resp.Data["keys"], test.name)
+	}
+
+	t.Run("invalid_operation", func(t *testing.T) {
+		resp, err := testRolesListFiltered(req, backend, t, map[string]interface{}{"operation": "publish"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "operation 'publish' is not allowed")
+	})
+
+	t.Run("detailed", func(t *testing.T) {
+		// a failed sync is reported by the next listing
+		client := newRecordingClient()
+		client.rejectRole(callCreateGroup, "docker-read")
+		backend.client = client
+		resp, err := testRoleUpdate(req, backend, t, "docker-read", map[string]interface{}{
+			"permission_targets": `[{"repo": {"repositories": ["docker-prod-local"], "operations": ["read", "annotate"]}}]`,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+
+		resp, err = testRolesListFiltered(req, backend, t, map[string]interface{}{
+			"repository": "docker-prod-local",
+			"detailed":   true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		keyInfo := resp.Data["key_info"].(map[string]interface{})
+		require.Len(t, keyInfo, 3)
+
+		publish := keyInfo["docker-publish"].(map[string]interface{})
+		assert.Equal(t, int64(900), publish["token_ttl"])
+		assert.Equal(t, []string{"docker-prod-local"}, publish["repositories"])
+		assert.Equal(t, []string{"read", "write"}, publish["operations"])
+		assert.Equal(t, "platform", publish["owner"])
+		assert.Equal(t, true, publish["last_sync"].(map[string]interface{})["success"])
+
+		read := keyInfo["docker-read"].(map[string]interface{})
+		assert.Equal(t, []string{"read"}, read["operations"], "failed sync must keep the stored role")
+		lastSync := read["last_sync"].(map[string]interface{})
+		assert.Equal(t, false, lastSync["success"])
+		assert.Contains(t, lastSync["error"], "failed to create an artifactory group")
+	})
+}
+
 func TestPathRoleFail(t *testing.T) {
 	t.Parallel()
 	req, backend := newArtMockEnv(t)
@@ -649,3 +816,12 @@ func testRoleList(req *logical.Request, b logical.Backend, t *testing.T) (*logic
 	resp, err := b.HandleRequest(context.Background(), req)
 	return resp, err
 }
+
+func testRolesListFiltered(req *logical.Request, b logical.Backend, t *testing.T, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.ListOperation
+	req.Path = "roles"
+	req.Data = data
+	resp, err := b.HandleRequest(context.Background(), req)
+	return resp, err
+}
@@ -0,0 +1,249 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	rolesApplyPath = "roles-apply"
+
+	defaultApplyParallelism = 4
+	maxApplyParallelism     = 16
+
+	applyActionCreate    = "create"
+	applyActionUpdate    = "update"
+	applyActionDelete    = "delete"
+	applyActionUnchanged = "unchanged"
+)
+
+// roleApplyChange is a single role change computed by roles-apply
+type roleApplyChange struct {
+	action string
+	entry  roleBundleEntry
+}
+
+// planRoleApply compares the desired roles with the stored roles
+func (backend *ArtifactoryBackend) planRoleApply(ctx context.Context, storage logical.Storage, desired []roleBundleEntry, prune bool) (map[string]roleApplyChange, error) {
+	changes := map[string]roleApplyChange{}
+	for _, e := range desired {
+		e = e.withDefaults()
+		role, err := getRoleEntry(ctx, storage, e.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case role == nil:
+			changes[e.Name] = roleApplyChange{action: applyActionCreate, entry: e}
+		case reflect.DeepEqual(newRoleBundleEntry(role), e):
+			changes[e.Name] = roleApplyChange{action: applyActionUnchanged, entry: e}
+		default:
+			changes[e.Name] = roleApplyChange{action: applyActionUpdate, entry: e}
+		}
+	}
+
+	if !prune {
+		return changes, nil
+	}
+
+	existing, err := backend.listRoleEntries(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleName := range existing {
+		if _, ok := changes[roleName]; !ok {
+			changes[roleName] = roleApplyChange{action: applyActionDelete, entry: roleBundleEntry{Name: roleName}}
+		}
+	}
+	return changes, nil
+}
+
+func (backend *ArtifactoryBackend) pathRolesApply(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawRoles := data.Get("roles").(string)
+	if rawRoles == "" {
+		return logical.ErrorResponse("roles not supplied"), nil
+	}
+	prune := data.Get("prune").(bool)
+	parallelism := data.Get("parallelism").(int)
+	if parallelism < 1 || parallelism > maxApplyParallelism {
+		return logical.ErrorResponse(fmt.Sprintf("parallelism must be between 1 and %d", maxApplyParallelism)), nil
+	}
+
+	var desired []roleBundleEntry
+	if err := json.Unmarshal([]byte(rawRoles), &desired); err != nil {
+		return logical.ErrorResponse("Error unmarshal roles. Expecting list of roles - " + err.Error()), nil
+	}
+	// an empty set is more likely a broken input than the intent to delete every role
+	if prune && len(desired) == 0 && !data.Get("allow_empty").(bool) {
+		return logical.ErrorResponse("pruning with an empty set of roles deletes every role, set allow_empty to confirm"), nil
+	}
+
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+
+	// validate the whole set before changing anything
+	var merr *multierror.Error
+	seen := map[string]bool{}
+	for _, e := range desired {
+		if seen[e.Name] {
+			merr = multierror.Append(merr, fmt.Errorf("role '%s' is defined more than once", e.Name))
+			continue
+		}
+		seen[e.Name] = true
+		if err := e.validate(config); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("role '%s': %s", e.Name, err.Error()))
+		}
+	}
+	if err := merr.ErrorOrNil(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	changes, err := backend.planRoleApply(ctx, req.Storage, desired, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	results := map[string]interface{}{}
+	failures := 0
+
+	for roleName, change := range changes {
+		result := map[string]interface{}{
+			"action":  change.action,
+			"success": true,
+		}
+		results[roleName] = result
+		if change.action == applyActionUnchanged {
+			continue
+		}
+
+		wg.Add(1)
+		go func(roleName string, change roleApplyChange, result map[string]interface{}) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var warnings []string
+			var err error
+			switch change.action {
+			case applyActionDelete:
+				warnings, err = backend.deleteRole(ctx, req, roleName)
+			default:
+				_, warnings, err = backend.applyRole(ctx, req, config, roleName, change.entry.update(), change.action == applyActionCreate)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				backend.Logger().Warn("unable to apply role", "role_name", roleName, "action", change.action, "error", err)
+				result["success"] = false
+				result["error"] = err.Error()
+				failures++
+			}
+			if len(warnings) > 0 {
+				result["warnings"] = warnings
+			}
+		}(roleName, change, result)
+	}
+	wg.Wait()
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"roles": results,
+		},
+	}
+	if failures > 0 {
+		resp.AddWarning(fmt.Sprintf("%d of %d role changes failed", failures, len(changes)))
+	}
+	return resp, nil
+}
+
+func pathRolesApply(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: rolesApplyPath,
+			Fields: map[string]*framework.FieldSchema{
+				"roles": {
+					Type:        framework.TypeString,
+					Description: "JSON list of the desired roles",
+				},
+				"prune": {
+					Type:        framework.TypeBool,
+					Description: "Delete roles which are not in the desired set",
+					Default:     false,
+				},
+				"allow_empty": {
+					Type:        framework.TypeBool,
+					Description: "Allow pruning with an empty set of roles, deleting every role",
+					Default:     false,
+				},
+				"parallelism": {
+					Type:        framework.TypeInt,
+					Description: fmt.Sprintf("Number of roles applied concurrently. At most %d", maxApplyParallelism),
+					Default:     defaultApplyParallelism,
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathRolesApply,
+			},
+			HelpSynopsis:    pathRolesApplyHelpSyn,
+			HelpDescription: pathRolesApplyHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathRolesApplyHelpSyn = `Apply a desired set of roles.`
+const pathRolesApplyHelpDesc = `
+This path takes the full desired set of roles as a JSON list, in the same format
+as the roles of a roles-export bundle:
+
+[
+  {
+    "name": "ci-role",
+    "token_ttl": 600,
+    "max_ttl": 3600,
+    "permission_targets": "[{\"repo\": {\"repositories\": [\"libs-local\"], \"operations\": [\"read\"]}}]",
+    "docker_registry": ""
+  }
+]
+
+Roles which do not exist are created and roles which differ from their desired
+state are updated. Omitted TTLs default to the role defaults. With "prune" set,
+roles missing from the set are deleted along with their Artifactory resources.
+An empty set with "prune" is rejected unless "allow_empty" is set as well.
+
+The whole set is validated before any role is changed. Changes are applied
+concurrently, up to "parallelism" roles at a time, and the action, outcome and
+warnings are returned for each role. Failing roles do not stop the others.
+`
@@ -0,0 +1,235 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRolesApply(t *testing.T) {
+	t.Parallel()
+
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	}
+
+	// role_a is unchanged, role_b is updated, role_c is missing from the set and role_d is new
+	setup := func(t *testing.T) (*logical.Request, logical.Backend) {
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+		for _, roleName := range []string{"role_a", "role_b", "role_c"} {
+			mustRoleCreate(req, b, t, roleName, map[string]interface{}{
+				"permission_targets": testBundlePt,
+			})
+		}
+		return req, b
+	}
+	desired, err := json.Marshal([]roleBundleEntry{
+		{Name: "role_a", PermissionTargets: testBundlePt},
+		{Name: "role_b", PermissionTargets: testBundlePt, TokenTTL: 600},
+		{Name: "role_d", PermissionTargets: testBundlePt},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		prune   bool
+		actions map[string]string
+		roles   []string
+	}{
+		{
+			name: "without_prune",
+			actions: map[string]string{
+				"role_a": applyActionUnchanged,
+				"role_b": applyActionUpdate,
+				"role_d": applyActionCreate,
+			},
+			roles: []string{"role_a", "role_b", "role_c", "role_d"},
+		},
+		{
+			name:  "with_prune",
+			prune: true,
+			actions: map[string]string{
+				"role_a": applyActionUnchanged,
+				"role_b": applyActionUpdate,
+				"role_c": applyActionDelete,
+				"role_d": applyActionCreate,
+			},
+			roles: []string{"role_a", "role_b", "role_d"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			req, b := setup(t)
+
+			resp, err := testRolesApply(req, b, t, map[string]interface{}{
+				"roles":       string(desired),
+				"prune":       test.prune,
+				"parallelism": 2,
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+			assert.Empty(t, resp.Warnings)
+
+			results := resp.Data["roles"].(map[string]interface{})
+			require.Len(t, results, len(test.actions))
+			for roleName, action := range test.actions {
+				result := results[roleName].(map[string]interface{})
+				assert.Equal(t, action, result["action"], "role %s", roleName)
+				assert.Equal(t, true, result["success"], "role %s", roleName)
+			}
+
+			roles, err := req.Storage.List(context.Background(), rolesPrefix+"/")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.roles, roles)
+
+			role, err := getRoleEntry(context.Background(), req.Storage, "role_b")
+			require.NoError(t, err)
+			assert.Equal(t, 600*time.Second, role.TokenTTL)
+		})
+	}
+
+	t.Run("partial_failure", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+		client := newRecordingClient()
+		client.rejectRole(callCreateGroup, "role_d")
+		b.(*ArtifactoryBackend).client = client
+
+		resp, err := testRolesApply(req, b, t, map[string]interface{}{
+			"roles": string(desired),
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, []string{"1 of 3 role changes failed"}, resp.Warnings)
+
+		results := resp.Data["roles"].(map[string]interface{})
+		failed := results["role_d"].(map[string]interface{})
+		assert.Equal(t, false, failed["success"])
+		assert.Contains(t, failed["error"], "400 Bad Request")
+		assert.Equal(t, true, results["role_b"].(map[string]interface{})["success"])
+
+		role, err := getRoleEntry(context.Background(), req.Storage, "role_d")
+		require.NoError(t, err)
+		assert.Nil(t, role)
+	})
+
+	t.Run("invalid_set", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+
+		tests := []struct {
+			name string
+			data map[string]interface{}
+			err  string
+		}{
+			{
+				name: "missing_roles",
+				data: map[string]interface{}{},
+				err:  "roles not supplied",
+			},
+			{
+				name: "invalid_json",
+				data: map[string]interface{}{"roles": `{"name": "role_a"}`},
+				err:  "Expecting list of roles",
+			},
+			{
+				name: "invalid_parallelism",
+				data: map[string]interface{}{"roles": "[]", "parallelism": 0},
+				err:  "parallelism must be between 1 and 16",
+			},
+			{
+				name: "duplicate_role",
+				data: map[string]interface{}{"roles": `[{"name": "role_x", "permission_targets": "[]"}, {"name": "role_x"}]`},
+				err:  "role 'role_x' is defined more than once",
+			},
+			{
+				name: "empty_set_with_prune",
+				data: map[string]interface{}{"roles": "[]", "prune": true},
+				err:  "set allow_empty to confirm",
+			},
+			{
+				name: "null_set_with_prune",
+				data: map[string]interface{}{"roles": "null", "prune": true},
+				err:  "set allow_empty to confirm",
+			},
+			{
+				name: "invalid_role",
+				data: map[string]interface{}{"roles": `[{"name": "role_a"}]`, "prune": true},
+				err:  "role 'role_a': permission targets are empty",
+			},
+		}
+
+		for _, test := range tests {
+			resp, err := testRolesApply(req, b, t, test.data)
+			require.NoError(t, err, test.name)
+			require.True(t, resp.IsError(), "expecting error for %s", test.name)
+			assert.Contains(t, resp.Data["error"], test.err, test.name)
+		}
+
+		// nothing is pruned when the set is invalid
+		roles, err := req.Storage.List(context.Background(), rolesPrefix+"/")
+		require.NoError(t, err)
+		assert.Len(t, roles, 3)
+
+		// an empty set without prune changes nothing
+		resp, err := testRolesApply(req, b, t, map[string]interface{}{"roles": "[]"})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Empty(t, resp.Data["roles"])
+	})
+
+	t.Run("empty_set_allowed", func(t *testing.T) {
+		t.Parallel()
+		req, b := setup(t)
+
+		resp, err := testRolesApply(req, b, t, map[string]interface{}{
+			"roles":       "[]",
+			"prune":       true,
+			"allow_empty": true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		results := resp.Data["roles"].(map[string]interface{})
+		require.Len(t, results, 3)
+		for roleName, result := range results {
+			assert.Equal(t, applyActionDelete, result.(map[string]interface{})["action"], "role %s", roleName)
+		}
+
+		roles, err := req.Storage.List(context.Background(), rolesPrefix+"/")
+		require.NoError(t, err)
+		assert.Empty(t, roles)
+	})
+}
+
+func testRolesApply(req *logical.Request, b logical.Backend, t *testing.T, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.UpdateOperation
+	req.Path = rolesApplyPath
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
@@ -16,6 +16,7 @@ package artifactorysecrets
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -35,6 +36,15 @@ var createTokenSchema = map[string]*framework.FieldSchema{
 		Description: "The duration in seconds after which the token will expire. Default 3600 seconds",
 		Default:     60 * 60,
 	},
+	"format": {
+		Type:        framework.TypeString,
+		Description: "Format of the returned credentials. One of raw, docker, npm, pip, maven, gradle, go or helm. Default raw",
+		Default:     tokenFormatRaw,
+	},
+	"reference_token": {
+		Type:        framework.TypeBool,
+		Description: "Also return a JFrog Access reference token, used instead of the access token in formatted credentials. Defaults to the role's reference_token",
+	},
 }
 
 // create the basic jwt token with an expiry within the claim
@@ -45,9 +55,27 @@ func (backend *ArtifactoryBackend) pathTokenCreateUpdate(ctx context.Context, re
 	// get the role by name
 	roleEntry, err := getRoleEntry(ctx, req.Storage, roleName)
 	if roleEntry == nil || err != nil {
+		emitTokenFailed(roleName, tokenFailureRoleNotFound)
 		return logical.ErrorResponse(fmt.Sprintf("Role name '%s' not recognised", roleName)), nil
 	}
 
+	format := data.Get("format").(string)
+	formatter, ok := tokenFormatters[format]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("Unknown format '%s', expecting one of %v", format, tokenFormats())), nil
+	}
+
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+	if err := checkTokenFormat(format, config, roleEntry); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	var tokenEntry TokenCreateEntry
 
 	ttlRaw, ok := data.GetOk("ttl")
@@ -57,13 +85,60 @@ func (backend *ArtifactoryBackend) pathTokenCreateUpdate(ctx context.Context, re
 		tokenEntry.TTL = roleEntry.TokenTTL
 	}
 
+	tokenEntry.ReferenceToken = roleEntry.ReferenceToken
+	if referenceToken, ok := data.GetOk("reference_token"); ok {
+		tokenEntry.ReferenceToken = referenceToken.(bool)
+	}
+
 	if tokenEntry.TTL > roleEntry.MaxTTL {
+		emitTokenFailed(roleName, tokenFailureTTLExceeded)
 		return logical.ErrorResponse(fmt.Sprintf("Token ttl is greater than role max ttl '%d'", roleEntry.MaxTTL)), nil
 	}
 
-	token, err := backend.createTokenEntry(ctx, req.Storage, tokenEntry, roleEntry)
+	// roles with identity templates issue tokens from a role resolved for the caller
+	issuingRole := roleEntry
+	if hasIdentityTemplates(roleEntry.PermissionTargets) {
+		issuingRole, err = backend.identityRole(ctx, req, roleEntry)
+		if errors.Is(err, logical.ErrReadOnly) {
+			// performance standbys forward the request to the active node
+			return nil, err
+		}
+		if errors.Is(err, errCircuitOpen) {
+			emitTokenFailed(roleName, tokenFailureCircuitOpen)
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if err != nil {
+			emitTokenFailed(roleName, tokenFailureIdentity)
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	token, err := backend.createTokenEntry(ctx, req.Storage, tokenEntry, issuingRole)
+	if errors.Is(err, errCircuitOpen) {
+		emitTokenFailed(roleName, tokenFailureCircuitOpen)
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if errors.Is(err, errReferenceTokensUnsupported) || errors.Is(err, errProjectTokensUnsupported) {
+		emitTokenFailed(roleName, tokenFailureArtifactory)
+		return logical.ErrorResponse(fmt.Sprintf("Error creating token, %s", err)), nil
+	}
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("Error creating token, %#v", err)), err
+		emitTokenFailed(roleName, tokenFailureArtifactory)
+		return logical.ErrorResponse(fmt.Sprintf("Error creating token, %s", err)), err
+	}
+	emitTokenIssued(roleName)
+
+	// tools choking on the long access token get the reference token in their credentials
+	credential := token["access_token"].(string)
+	if tokenEntry.ReferenceToken {
+		credential = token["reference_token"].(string)
+	}
+	formatted, err := formatter(config, roleEntry, token["username"].(string), credential)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	for k, v := range formatted {
+		token[k] = v
 	}
 
 	return &logical.Response{Data: token}, nil
@@ -93,7 +168,35 @@ A role, binding permission targets to specific Artifactory resources, will be sp
 by name - for example, if this backend is mounted at "artifactory",
 then "artifactory/token/deploy" would generate tokens for the "deploy" role.
 
+The credentials can be rendered for a specific tool with the "format" field:
+
+  raw     access_token and username only (default)
+  docker  a docker config.json with an auths entry for the role's docker
+          registry, or the host of the configured base_url
+  npm     an .npmrc with an auth token for each repository of the role
+  pip     a pip.conf with an index url for each repository of the role
+  maven   a settings.xml with a server block for each repository of the role
+  gradle  a gradle.properties with the Artifactory url and credentials
+  go      a GOPROXY value with each repository of the role
+  helm    a helm repositories.yaml with each repository of the role
+
+Repository urls are built from the configured base_url and the repositories
+of the role's permission targets.
+
+With "reference_token" set, or set on the role, JFrog Access also returns a
+short reference token as "reference_token". Formatted credentials then use it
+instead of the access token. Reference tokens need Artifactory 7.38.4 or above.
+
+Roles whose patterns use identity templates resolve them for the entity of the
+caller. Callers with the same resolved patterns share a group and permission
+targets, which are created on first use and removed once no token issued from
+them can still be valid.
+
 On the backend, each role is associated with a group.
 The token will be scoped to this group. Tokens have a
 short-term lease (default 10-mins) associated with them but cannot be renewed.
+
+Tokens are created with the JFrog Access token API on Artifactory 7.21.1 or
+above, and with the legacy Artifactory token API otherwise. Tokens of roles with
+project roles are scoped to them, which needs the JFrog Access token API.
 `
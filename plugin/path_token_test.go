@@ -17,6 +17,7 @@ package artifactorysecrets
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"testing"
 
@@ -78,7 +79,479 @@ func TestArtAccIssueToken(t *testing.T) {
 
 }
 
+func TestIssueTokenFormat(t *testing.T) {
+	t.Parallel()
+
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/artifactory",
+		"bearer_token": "mybearertoken",
+	})
+
+	roleName := "test_token_format_role"
+	mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"name":               roleName,
+		"permission_targets": `[{"repo": {"repositories": ["docker-local"], "operations": ["read"]}}]`,
+	})
+
+	t.Run("docker", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, roleName, map[string]interface{}{
+			"role_name": roleName,
+			"format":    "docker",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		assert.Equal(t, "example.jfrog.io", resp.Data["registry"])
+		assert.Contains(t, resp.Data["docker_config"], `"example.jfrog.io"`)
+		assert.Contains(t, resp.Data, "access_token")
+	})
+
+	t.Run("npm", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, roleName, map[string]interface{}{
+			"role_name": roleName,
+			"format":    "npm",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		assert.Contains(t, resp.Data["npmrc"], "registry=https://example.jfrog.io/artifactory/api/npm/docker-local/")
+		assert.Contains(t, resp.Data, "access_token")
+	})
+
+	t.Run("unknown_format", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, roleName, map[string]interface{}{
+			"role_name": roleName,
+			"format":    "yaml",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "Unknown format 'yaml'")
+	})
+}
+
+func TestFakeArtifactoryTokenFormatFails(t *testing.T) {
+	t.Parallel()
+
+	req, backend, fake := newFakeArtifactoryEnv(t)
+	mustRoleCreate(req, backend, t, "any", map[string]interface{}{
+		"permission_targets": `[{"repo": {"repositories": ["ANY LOCAL"], "operations": ["read"]}}]`,
+	})
+
+	// the format is checked before a token is created, as failing after would leak it
+	for _, format := range []string{tokenFormatNpm, tokenFormatPip, tokenFormatMaven, tokenFormatGo, tokenFormatHelm} {
+		resp, err := testIssueToken(req, backend, t, "any", map[string]interface{}{
+			"role_name": "any",
+			"format":    format,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error for format %s", format)
+		assert.Contains(t, resp.Data["error"], "role 'any' has no named repositories to render "+format+" config for")
+	}
+	assert.Empty(t, fake.issuedTokens())
+}
+
+func TestIssueTokenDockerRegistryFails(t *testing.T) {
+	t.Parallel()
+
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "artifactory",
+		"bearer_token": "mybearertoken",
+	})
+	client := newRecordingClient()
+	backend.(*ArtifactoryBackend).client = client
+	mustRoleCreate(req, backend, t, "docker", map[string]interface{}{
+		"permission_targets": `[{"repo": {"repositories": ["docker-local"], "operations": ["read"]}}]`,
+	})
+
+	// the registry is resolved before a token is created, as failing after would leak it
+	resp, err := testIssueToken(req, backend, t, "docker", map[string]interface{}{
+		"role_name": "docker",
+		"format":    tokenFormatDocker,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError(), "expecting error")
+	assert.Contains(t, resp.Data["error"], "unable to determine docker registry from base url 'artifactory/'")
+	assert.Zero(t, client.tokens)
+
+	// a role registry doesn't need the base url
+	mustRoleUpdate(req, backend, t, "docker", map[string]interface{}{
+		"docker_registry": "docker.example.jfrog.io",
+	})
+	resp, err = testIssueToken(req, backend, t, "docker", map[string]interface{}{
+		"role_name": "docker",
+		"format":    tokenFormatDocker,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, "docker.example.jfrog.io", resp.Data["registry"])
+	assert.Equal(t, 1, client.tokens)
+}
+
 // create the token given the parameters
+func TestFakeArtifactoryRoleFlow(t *testing.T) {
+	t.Parallel()
+
+	req, backend, fake := newFakeArtifactoryEnv(t)
+	twoPts := `[{"repo": {"repositories": ["libs-local"], "operations": ["read"]}}, {"build": {"repositories": ["artifactory-build-info"], "operations": ["read"]}}]`
+
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": twoPts,
+		"description":        "CI builds",
+	})
+	role, err := getRoleEntry(context.Background(), req.Storage, "ci")
+	require.NoError(t, err)
+
+	group, ok := fake.group(groupName(role))
+	require.True(t, ok)
+	assert.Equal(t, "CI builds", group.Description)
+	groups, pts := fake.counts()
+	assert.Equal(t, 1, groups)
+	assert.Equal(t, 2, pts)
+
+	resp, err := testIssueToken(req, backend, t, "ci", map[string]interface{}{"role_name": "ci"})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, "fake-access-token-1", resp.Data["access_token"])
+	tokens := fake.issuedTokens()
+	require.Len(t, tokens, 1)
+	assert.True(t, tokens[0].Access, "token not issued by jfrog access")
+	assert.Equal(t, "applied-permissions/groups:"+groupName(role), tokens[0].Scope)
+
+	// dropping a permission target removes it from Artifactory
+	mustRoleUpdate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testBundlePt,
+	})
+	_, ok = fake.permission(permissionTargetName("ci", 1))
+	assert.False(t, ok)
+	groups, pts = fake.counts()
+	assert.Equal(t, 1, groups)
+	assert.Equal(t, 1, pts)
+
+	mustRoleDelete(req, backend, t, "ci")
+	groups, pts = fake.counts()
+	assert.Equal(t, 0, groups)
+	assert.Equal(t, 0, pts)
+}
+
+func TestFakeArtifactoryReferenceToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		role      bool
+		request   map[string]interface{}
+		reference bool
+	}{
+		{name: "role_default", role: true, request: map[string]interface{}{}, reference: true},
+		{name: "request", request: map[string]interface{}{"reference_token": true}, reference: true},
+		{name: "request_overrides_role", role: true, request: map[string]interface{}{"reference_token": false}},
+		{name: "not_requested", request: map[string]interface{}{}},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			req, backend, _ := newFakeArtifactoryEnv(t)
+			mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+				"permission_targets": `[{"repo": {"repositories": ["npm-local"], "operations": ["read"]}}]`,
+				"reference_token":    test.role,
+			})
+
+			test.request["role_name"] = "ci"
+			test.request["format"] = "npm"
+			resp, err := testIssueToken(req, backend, t, "ci", test.request)
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+			assert.Equal(t, "fake-access-token-1", resp.Data["access_token"])
+			if !test.reference {
+				assert.NotContains(t, resp.Data, "reference_token")
+				assert.Contains(t, resp.Data["npmrc"], ":_authToken=fake-access-token-1")
+				return
+			}
+			assert.Equal(t, "fake-reference-token-1", resp.Data["reference_token"])
+			assert.Contains(t, resp.Data["npmrc"], ":_authToken=fake-reference-token-1")
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+		req, backend, fake := newFakeArtifactoryEnv(t)
+		fake.setVersion("7.27.3")
+		mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+			"permission_targets": testBundlePt,
+			"reference_token":    true,
+		})
+
+		resp, err := testIssueToken(req, backend, t, "ci", map[string]interface{}{"role_name": "ci"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "reference tokens need JFrog Access on Artifactory 7.38.4 or above")
+		assert.Empty(t, fake.issuedTokens())
+	})
+}
+
+func TestFakeArtifactoryProjectRoles(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, fake := newFakeArtifactoryEnv(t)
+	fake.addProject("ml")
+	fake.addProject("ops")
+
+	// a role with project roles only
+	mustRoleCreate(req, backend, t, "ml", map[string]interface{}{
+		"project_key":   "ml",
+		"project_roles": "Developer,Viewer",
+	})
+	role, err := getRoleEntry(ctx, req.Storage, "ml")
+	require.NoError(t, err)
+	require.NotNil(t, role)
+	group := groupName(role)
+	roles, ok := fake.projectRoles("ml", group)
+	require.True(t, ok, "group is not a member of the project")
+	assert.Equal(t, []string{"Developer", "Viewer"}, roles)
+	groups, pts := fake.counts()
+	assert.Equal(t, 1, groups)
+	assert.Equal(t, 0, pts)
+
+	resp, err := testRoleRead(req, backend, t, "ml")
+	require.NoError(t, err)
+	assert.Equal(t, "ml", resp.Data["project_key"])
+	assert.Equal(t, []string{"Developer", "Viewer"}, resp.Data["project_roles"])
+
+	issue := func() fakeToken {
+		t.Helper()
+		resp, err := testIssueToken(req, backend, t, "ml", map[string]interface{}{"role_name": "ml"})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		tokens := fake.issuedTokens()
+		return tokens[len(tokens)-1]
+	}
+	assert.Equal(t, "applied-permissions/roles:ml:Developer,Viewer", issue().Scope)
+
+	// permission targets add the group to the scope
+	mustRoleUpdate(req, backend, t, "ml", map[string]interface{}{
+		"permission_targets": testBundlePt,
+	})
+	assert.Equal(t, "applied-permissions/groups:"+group+" applied-permissions/roles:ml:Developer,Viewer", issue().Scope)
+
+	// moving the role to another project leaves the old one
+	mustRoleUpdate(req, backend, t, "ml", map[string]interface{}{
+		"project_key":   "ops",
+		"project_roles": "Viewer",
+	})
+	_, ok = fake.projectRoles("ml", group)
+	assert.False(t, ok, "group is still a member of the old project")
+	roles, ok = fake.projectRoles("ops", group)
+	require.True(t, ok, "group is not a member of the new project")
+	assert.Equal(t, []string{"Viewer"}, roles)
+
+	// clearing the project key clears the project roles
+	mustRoleUpdate(req, backend, t, "ml", map[string]interface{}{
+		"project_key": "",
+	})
+	_, ok = fake.projectRoles("ops", group)
+	assert.False(t, ok, "group is still a member of the project")
+	assert.Equal(t, "applied-permissions/groups:"+group, issue().Scope)
+
+	mustRoleDelete(req, backend, t, "ml")
+	groups, pts = fake.counts()
+	assert.Equal(t, 0, groups)
+	assert.Equal(t, 0, pts)
+}
+
+func TestFakeArtifactoryProjectRolesFail(t *testing.T) {
+	t.Parallel()
+
+	t.Run("role_errors", func(t *testing.T) {
+		t.Parallel()
+		req, backend, fake := newFakeArtifactoryEnv(t)
+		fake.addProject("ml")
+		mustRoleCreate(req, backend, t, "ml", map[string]interface{}{
+			"project_key":   "ml",
+			"project_roles": "Developer",
+		})
+
+		tests := []struct {
+			name string
+			role string
+			data map[string]interface{}
+			err  string
+		}{
+			{
+				name: "unknown_project",
+				role: "other",
+				data: map[string]interface{}{"project_key": "other", "project_roles": "Developer"},
+				err:  "failed to add the group to project 'other'",
+			},
+			{
+				name: "identity_templates",
+				role: "other",
+				data: map[string]interface{}{"project_key": "ml", "project_roles": "Developer", "permission_targets": testIdentityPt},
+				err:  "project roles are not supported with identity templates",
+			},
+			{
+				name: "no_permission_targets_left",
+				role: "ml",
+				data: map[string]interface{}{"project_key": ""},
+				err:  "permission targets are required for roles without project roles",
+			},
+			{
+				name: "invalid_project_role",
+				role: "ml",
+				data: map[string]interface{}{"project_roles": "Release Manager"},
+				err:  "project role 'Release Manager' is not allowed",
+			},
+		}
+		for _, test := range tests {
+			resp, err := testRoleUpdate(req, backend, t, test.role, test.data)
+			require.NoError(t, err, test.name)
+			require.True(t, resp.IsError(), "%s: expecting error", test.name)
+			assert.Contains(t, resp.Data["error"], test.err, test.name)
+		}
+
+		role, err := getRoleEntry(context.Background(), req.Storage, "other")
+		require.NoError(t, err)
+		assert.Nil(t, role, "role must not be saved when its sync failed")
+	})
+
+	t.Run("legacy_tokens", func(t *testing.T) {
+		t.Parallel()
+		req, backend, fake := newFakeArtifactoryEnv(t)
+		fake.addProject("ml")
+		mustRoleCreate(req, backend, t, "ml", map[string]interface{}{
+			"project_key":   "ml",
+			"project_roles": "Developer",
+		})
+		fake.setVersion("7.17.5")
+
+		resp, err := testIssueToken(req, backend, t, "ml", map[string]interface{}{"role_name": "ml"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "project roles need JFrog Access tokens on Artifactory 7.21.1 or above")
+		assert.Empty(t, fake.issuedTokens())
+	})
+}
+
+func TestFakeArtifactoryServiceUser(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	req, backend, fake := newFakeArtifactoryEnv(t)
+	mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+		"permission_targets": testBundlePt,
+		"mode":               "user",
+	})
+	role, err := getRoleEntry(ctx, req.Storage, "ci")
+	require.NoError(t, err)
+	require.NotNil(t, role)
+	user := serviceUserName(role)
+
+	_, ok := fake.user(user)
+	require.True(t, ok, "service user not created")
+	groups, pts := fake.counts()
+	assert.Equal(t, 0, groups)
+	assert.Equal(t, 1, pts)
+	pt, _ := fake.permission(permissionTargetName("ci", 0))
+	assert.Equal(t, map[string][]string{user: {"read"}}, pt.Repo.Actions.Users)
+
+	resp, err := testRoleRead(req, backend, t, "ci")
+	require.NoError(t, err)
+	assert.Equal(t, "user", resp.Data["mode"])
+
+	resp, err = testIssueToken(req, backend, t, "ci", map[string]interface{}{"role_name": "ci"})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, user, resp.Data["username"])
+	tokens := fake.issuedTokens()
+	require.Len(t, tokens, 1)
+	assert.Equal(t, user, tokens[0].Username)
+	assert.Equal(t, "applied-permissions/user", tokens[0].Scope)
+
+	// description updates don't touch the service user
+	mustRoleUpdate(req, backend, t, "ci", map[string]interface{}{
+		"description": "CI builds",
+	})
+	assert.Equal(t, 1, fake.requestCount(http.MethodPut, fakeUsersPath))
+
+	tests := []struct {
+		name string
+		role string
+		data map[string]interface{}
+		err  string
+	}{
+		{name: "change_mode", role: "ci", data: map[string]interface{}{"mode": "group"}, err: "the mode of role 'ci' can't be changed"},
+		{name: "unknown_mode", role: "other", data: map[string]interface{}{"mode": "robot", "permission_targets": testBundlePt}, err: "unknown mode 'robot'"},
+		{name: "project_roles", role: "other", data: map[string]interface{}{"mode": "user", "project_key": "ml", "project_roles": "Developer"}, err: "project roles are only supported in group mode"},
+	}
+	for _, test := range tests {
+		resp, err := testRoleUpdate(req, backend, t, test.role, test.data)
+		require.NoError(t, err, test.name)
+		require.True(t, resp.IsError(), "%s: expecting error", test.name)
+		assert.Contains(t, resp.Data["error"], test.err, test.name)
+	}
+
+	mustRoleDelete(req, backend, t, "ci")
+	_, ok = fake.user(user)
+	assert.False(t, ok, "service user not deleted")
+	groups, pts = fake.counts()
+	assert.Equal(t, 0, groups)
+	assert.Equal(t, 0, pts)
+}
+
+func TestFakeArtifactoryFaults(t *testing.T) {
+	t.Parallel()
+
+	t.Run("permission_target_rejected", func(t *testing.T) {
+		t.Parallel()
+		req, backend, fake := newFakeArtifactoryEnv(t)
+		fake.fail(http.MethodPut, fakePermissionsPath, http.StatusBadRequest, 1)
+
+		resp, err := testRoleCreate(req, backend, t, "ci", map[string]interface{}{
+			"permission_targets": testBundlePt,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "Failed to create/update a permission target")
+		assert.Contains(t, resp.Data["error"], "400")
+
+		role, err := getRoleEntry(context.Background(), req.Storage, "ci")
+		require.NoError(t, err)
+		assert.Nil(t, role, "role must not be saved when its sync failed")
+	})
+
+	t.Run("server_error_retried", func(t *testing.T) {
+		t.Parallel()
+		req, backend, fake := newFakeArtifactoryEnv(t)
+		fake.fail(http.MethodPut, fakeGroupsPath, http.StatusServiceUnavailable, 1)
+
+		mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+			"permission_targets": testBundlePt,
+		})
+		assert.Equal(t, 2, fake.requestCount(http.MethodPut, fakeGroupsPath))
+		groups, pts := fake.counts()
+		assert.Equal(t, 1, groups)
+		assert.Equal(t, 1, pts)
+	})
+
+	t.Run("token_rejected", func(t *testing.T) {
+		t.Parallel()
+		req, backend, fake := newFakeArtifactoryEnv(t)
+		mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+			"permission_targets": testBundlePt,
+		})
+		fake.fail(http.MethodPost, fakeAccessTokenPath, http.StatusForbidden, -1)
+
+		resp, err := testIssueToken(req, backend, t, "ci", map[string]interface{}{"role_name": "ci"})
+		require.Error(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"], "Error creating token, failed to create a token: Server response: 403")
+	})
+}
+
 func testIssueToken(req *logical.Request, b logical.Backend, t *testing.T, roleName string, data map[string]interface{}) (*logical.Response, error) {
 	req.Operation = logical.UpdateOperation
 	req.Path = fmt.Sprintf("token/%s", roleName)
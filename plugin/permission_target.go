@@ -15,9 +15,14 @@
 package artifactorysecrets
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
 )
 
 type Permission struct {
@@ -33,6 +38,23 @@ type PermissionTarget struct {
 	// ReleaseBundle Permission `json:"release_bundle,omitempty"`
 }
 
+// parsePermissionTargets decodes and validates a user supplied permission targets JSON list
+func parsePermissionTargets(raw string) ([]PermissionTarget, error) {
+	var pts []PermissionTarget
+	if err := json.Unmarshal([]byte(raw), &pts); err != nil {
+		return nil, errors.New("Error unmarshal permission targets. Expecting list of permission targets - " + err.Error())
+	}
+	if len(pts) == 0 {
+		return nil, errors.New("Failed to parse any permission targets from given permission targets JSON")
+	}
+	for _, pt := range pts {
+		if err := pt.assertValid(); err != nil {
+			return nil, errors.New("Failed to validate a permission target - " + err.Error())
+		}
+	}
+	return pts, nil
+}
+
 // validate user supplied permission target
 func (pt PermissionTarget) assertValid() error {
 	var err *multierror.Error
@@ -46,6 +68,9 @@ func (pt PermissionTarget) assertValid() error {
 		} else if e := validateOperations(pt.Repo.Operations); e != nil {
 			err = multierror.Append(err, e)
 		}
+		if e := pt.Repo.validateIdentityTemplates("repo"); e != nil {
+			err = multierror.Append(err, e)
+		}
 	}
 
 	if pt.Build != nil {
@@ -57,6 +82,171 @@ func (pt PermissionTarget) assertValid() error {
 		} else if e := validateOperations(pt.Build.Operations); e != nil {
 			err = multierror.Append(err, e)
 		}
+		if e := pt.Build.validateIdentityTemplates("build"); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	return err.ErrorOrNil()
+}
+
+// hasIdentityTemplates reports whether the patterns of the permission target use identity templates
+func (pt PermissionTarget) hasIdentityTemplates() bool {
+	for _, p := range []*Permission{pt.Repo, pt.Build} {
+		if p == nil {
+			continue
+		}
+		for _, pattern := range p.patterns() {
+			if templated, _ := framework.ValidateIdentityTemplate(pattern); templated {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveIdentityTemplates returns a copy of the permission target with the identity templates
+// of its patterns populated for the entity
+func (pt PermissionTarget) resolveIdentityTemplates(entityID string, sysView logical.SystemView) (PermissionTarget, error) {
+	resolve := func(p *Permission) (*Permission, error) {
+		if p == nil {
+			return nil, nil
+		}
+		resolved := *p
+		resolved.IncludePatterns = make([]string, len(p.IncludePatterns))
+		resolved.ExcludePatterns = make([]string, len(p.ExcludePatterns))
+		for i, pattern := range p.IncludePatterns {
+			out, err := resolveIdentityPattern(pattern, entityID, sysView)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve pattern '%s' - %w", pattern, err)
+			}
+			resolved.IncludePatterns[i] = out
+		}
+		for i, pattern := range p.ExcludePatterns {
+			out, err := resolveIdentityPattern(pattern, entityID, sysView)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve pattern '%s' - %w", pattern, err)
+			}
+			resolved.ExcludePatterns[i] = out
+		}
+		return &resolved, nil
+	}
+
+	var resolved PermissionTarget
+	var err error
+	if resolved.Repo, err = resolve(pt.Repo); err != nil {
+		return resolved, err
+	}
+	if resolved.Build, err = resolve(pt.Build); err != nil {
+		return resolved, err
+	}
+	return resolved, nil
+}
+
+// resolveIdentityPattern populates the identity templates of the pattern one at a time. Values are
+// set by whoever manages the entity, so values which would widen the pattern past the folder or
+// name they stand for, such as '**' or '../x', are rejected.
+func resolveIdentityPattern(pattern, entityID string, sysView logical.SystemView) (string, error) {
+	var err error
+	out := identityTemplateRegex.ReplaceAllStringFunc(pattern, func(tmpl string) string {
+		if err != nil {
+			return tmpl
+		}
+		var value string
+		if value, err = framework.PopulateIdentityTemplate(tmpl, entityID, sysView); err != nil {
+			return tmpl
+		}
+		switch {
+		case value == "":
+			err = fmt.Errorf("identity template '%s' resolved to an empty value", tmpl)
+		case strings.ContainsAny(value, "*?/") || strings.Contains(value, ".."):
+			err = fmt.Errorf("identity template '%s' resolved to a value with '*', '?', '/' or '..'", tmpl)
+		}
+		return value
+	})
+	return out, err
+}
+
+// covers reports whether the permission may apply to the repository. Artifactory pseudo
+// repositories such as "ANY LOCAL" can't be resolved without Artifactory, so they always match.
+func (p Permission) covers(repository string) bool {
+	if repository == "" {
+		return true
+	}
+	for _, repo := range p.Repositories {
+		if repo == repository || isPseudoRepository(repo) {
+			return true
+		}
+	}
+	return false
+}
+
+// grants reports whether the permission grants the operation, an empty operation matches any
+func (p Permission) grants(operation string) bool {
+	if operation == "" {
+		return true
+	}
+	for _, op := range p.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the include and exclude patterns let the permission apply to the
+// repository path. Without include patterns everything is included, like in Artifactory.
+// Patterns with identity templates depend on the caller: when they decide, the permission is
+// allowed conditionally.
+func (p Permission) allows(path string) (allowed bool, conditional bool) {
+	hasIncludes, included := false, false
+	for _, pattern := range p.IncludePatterns {
+		if pattern == "" {
+			continue
+		}
+		hasIncludes = true
+		if !antMatch(pattern, path) {
+			continue
+		}
+		if !identityTemplateRegex.MatchString(pattern) {
+			included, conditional = true, false
+			break
+		}
+		included, conditional = true, true
+	}
+	if hasIncludes && !included {
+		return false, false
+	}
+	for _, pattern := range p.ExcludePatterns {
+		if pattern == "" || !antMatch(pattern, path) {
+			continue
+		}
+		if identityTemplateRegex.MatchString(pattern) {
+			conditional = true
+			continue
+		}
+		return false, false
+	}
+	return true, conditional
+}
+
+func (p Permission) patterns() []string {
+	patterns := make([]string, 0, len(p.IncludePatterns)+len(p.ExcludePatterns))
+	patterns = append(patterns, p.IncludePatterns...)
+	return append(patterns, p.ExcludePatterns...)
+}
+
+// validateIdentityTemplates checks the identity templates are well formed and only used in patterns
+func (p Permission) validateIdentityTemplates(section string) error {
+	var err *multierror.Error
+	for _, pattern := range p.patterns() {
+		if _, e := framework.ValidateIdentityTemplate(pattern); e != nil {
+			err = multierror.Append(err, fmt.Errorf("invalid '%s' pattern '%s' - %s", section, pattern, e.Error()))
+		}
+	}
+	for _, repo := range p.Repositories {
+		if templated, _ := framework.ValidateIdentityTemplate(repo); templated {
+			err = multierror.Append(err, fmt.Errorf("identity templates are only supported in '%s' patterns, not in repository '%s'", section, repo))
+		}
 	}
 	return err.ErrorOrNil()
 }
@@ -26,11 +26,21 @@ import (
 )
 
 const (
-	rolesPrefix = "roles"
+	rolesPrefix   = "roles"
+	roleIDsPrefix = "role-ids"
+)
+
+// role modes, whether permission targets are granted to a group or to a service user
+const (
+	roleModeGroup = "group"
+	roleModeUser  = "user"
 )
 
 type RoleStorageEntry struct {
 	// `json:"" structs:"" mapstructure:""`
+	// The schema version of the stored role
+	SchemaVersion int `json:"schema_version" structs:"schema_version" mapstructure:"schema_version"`
+
 	// The UUID that defines this role
 	RoleID string `json:"role_id" structs:"role_id" mapstructure:"role_id"`
 
@@ -43,8 +53,64 @@ type RoleStorageEntry struct {
 	// The provided name for the role
 	Name string `json:"name" structs:"name" mapstructure:"name"`
 
-	RawPermissionTargets string
-	PermissionTargets    []PermissionTarget
+	// The docker registry host for docker formatted credentials
+	DockerRegistry string `json:"docker_registry" structs:"docker_registry" mapstructure:"docker_registry"`
+
+	// The naming the Artifactory objects of the role were created with, the default naming when unset
+	Naming *Naming `json:"naming,omitempty" structs:"naming" mapstructure:"naming"`
+
+	// Whether permission targets are granted to a group or to a service user of the role
+	Mode string `json:"mode,omitempty" structs:"mode" mapstructure:"mode"`
+
+	// Whether tokens come with a reference token by default
+	ReferenceToken bool `json:"reference_token,omitempty" structs:"reference_token" mapstructure:"reference_token"`
+
+	// The JFrog project the group of the role is a member of, with the project roles tokens are scoped to
+	ProjectKey   string   `json:"project_key,omitempty" structs:"project_key" mapstructure:"project_key"`
+	ProjectRoles []string `json:"project_roles,omitempty" structs:"project_roles" mapstructure:"project_roles"`
+
+	// The role template the permission targets are rendered from, if any
+	Template string `json:"template,omitempty" structs:"template" mapstructure:"template"`
+
+	// The parameters the role template is rendered with
+	TemplateParams map[string]string `json:"template_params,omitempty" structs:"template_params" mapstructure:"template_params"`
+
+	// What the role is for, also used as the description of its Artifactory group
+	Description string `json:"description,omitempty" structs:"description" mapstructure:"description"`
+
+	// The team or person owning the role
+	Owner string `json:"owner,omitempty" structs:"owner" mapstructure:"owner"`
+
+	// Labels roles can be filtered by
+	Labels map[string]string `json:"labels,omitempty" structs:"labels" mapstructure:"labels"`
+
+	// Free-form metadata, not interpreted by the plugin
+	Metadata map[string]string `json:"metadata,omitempty" structs:"metadata" mapstructure:"metadata"`
+
+	// The permission targets as supplied, and parsed
+	RawPermissionTargets string             `json:"raw_permission_targets" structs:"raw_permission_targets" mapstructure:"raw_permission_targets"`
+	PermissionTargets    []PermissionTarget `json:"permission_targets" structs:"permission_targets" mapstructure:"permission_targets"`
+}
+
+// usesServiceUser tells whether the role is in user mode
+func (role RoleStorageEntry) usesServiceUser() bool {
+	return role.Mode == roleModeUser
+}
+
+// naming returns the naming of the Artifactory objects of the role
+func (role RoleStorageEntry) naming() Naming {
+	if role.Naming == nil {
+		return defaultNaming
+	}
+	return *role.Naming
+}
+
+// mode is the mode of the role, roles stored before modes existed use groups
+func (role RoleStorageEntry) mode() string {
+	if role.Mode == "" {
+		return roleModeGroup
+	}
+	return role.Mode
 }
 
 // validate checks whether a Role has been populated properly before saving
@@ -56,10 +122,11 @@ func (role RoleStorageEntry) validate() error {
 	if role.RoleID == "" {
 		err = multierror.Append(err, errors.New("role id is empty"))
 	}
-	if role.RawPermissionTargets == "" {
+	// roles with project roles don't need permission targets
+	if role.RawPermissionTargets == "" && role.ProjectKey == "" {
 		err = multierror.Append(err, errors.New("raw permission targets are empty"))
 	}
-	if role.PermissionTargets == nil {
+	if role.PermissionTargets == nil && role.ProjectKey == "" {
 		err = multierror.Append(err, errors.New("permission targets are empty"))
 	}
 	return err.ErrorOrNil()
@@ -71,12 +138,96 @@ func (role RoleStorageEntry) save(ctx context.Context, storage logical.Storage)
 		return err
 	}
 
+	role.SchemaVersion = roleSchemaVersion
 	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", rolesPrefix, role.Name), role)
 	if err != nil {
 		return err
 	}
 
-	return storage.Put(ctx, entry)
+	if err := storage.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	// role ids which don't derive from the role name are registered, so that a new role
+	// with the name they derive from doesn't get the same id and group
+	if role.RoleID != roleID(role.Name) {
+		idEntry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", roleIDsPrefix, role.RoleID), role.Name)
+		if err != nil {
+			return err
+		}
+		return storage.Put(ctx, idEntry)
+	}
+	return nil
+}
+
+// newRoleID returns an id for a new role. It derives from the role name, unless a renamed
+// role already uses that id.
+func newRoleID(ctx context.Context, storage logical.Storage, roleName string) (string, error) {
+	id := roleID(roleName)
+	for i := 1; ; i++ {
+		taken, err := roleIDTaken(ctx, storage, id)
+		if err != nil || !taken {
+			return id, err
+		}
+		// role names can't contain a NUL, so this never derives the id of another role name
+		id = roleID(fmt.Sprintf("%s\x00%d", roleName, i))
+	}
+}
+
+func roleIDTaken(ctx context.Context, storage logical.Storage, id string) (bool, error) {
+	entry, err := storage.Get(ctx, fmt.Sprintf("%s/%s", roleIDsPrefix, id))
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	var roleName string
+	if err := entry.DecodeJSON(&roleName); err != nil {
+		return false, err
+	}
+	role, err := getRoleEntry(ctx, storage, roleName)
+	if err != nil {
+		return false, err
+	}
+	// registrations of deleted or renamed roles are stale
+	return role != nil && role.RoleID == id, nil
+}
+
+// validateTTLs checks the role TTLs against each other and the config max TTL
+func (role RoleStorageEntry) validateTTLs(config *ConfigStorageEntry) error {
+	if role.MaxTTL > config.MaxTTL {
+		return fmt.Errorf("role max ttl is greater than config max ttl '%d'", config.MaxTTL)
+	}
+	if role.TokenTTL > role.MaxTTL {
+		return fmt.Errorf("role token ttl is greater than role max ttl '%d'", role.MaxTTL)
+	}
+	return nil
+}
+
+// hasLabels reports whether the role has all of the labels
+func (role RoleStorageEntry) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if v, ok := role.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// grants reports whether a permission target of the role grants the operation on the repository.
+// Empty arguments match anything, and builds are only matched when no repository is given.
+func (role RoleStorageEntry) grants(repository, operation string) bool {
+	if repository == "" && operation == "" {
+		return true
+	}
+	for _, pt := range role.PermissionTargets {
+		if pt.Repo != nil && pt.Repo.covers(repository) && pt.Repo.grants(operation) {
+			return true
+		}
+		if repository == "" && pt.Build != nil && pt.Build.grants(operation) {
+			return true
+		}
+	}
+	return false
 }
 
 func (role RoleStorageEntry) permissionTargetsHash() string {
@@ -92,18 +243,48 @@ func (backend *ArtifactoryBackend) roleLock(roleName string) *locksutil.LockEntr
 // persist in the data store
 func (backend *ArtifactoryBackend) saveRoleWithNewPermissionTargets(ctx context.Context, req *logical.Request, role *RoleStorageEntry, pts []PermissionTarget) (warning []string, err error) {
 	backend.Logger().Debug("Creating/Updating role with new permission targets")
+	// an update abandoned with warnings is still a failed sync
+	var abandoned error
+	defer func() {
+		syncErr := err
+		if syncErr == nil {
+			syncErr = abandoned
+		}
+		backend.roleSynced(ctx, req.Storage, role.Name, syncErr)
+	}()
 
 	oldPts := role.PermissionTargets
+	// permission targets with identity templates only exist in Artifactory once resolved
+	if hasIdentityTemplates(oldPts) {
+		oldPts = nil
+	}
+
+	if hasIdentityTemplates(pts) {
+		backend.Logger().Debug("permission targets use identity templates, resolving them at token time", "role_name", role.Name)
+		if len(oldPts) > 0 {
+			if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, oldPts, 0, true); cleanupErr != nil {
+				backend.Logger().Warn(
+					"unable to clean up unused old group and permission targets for role.",
+					"role_name", role.Name, "errors", cleanupErr)
+				warning = []string{cleanupErr.Error()}
+			}
+		}
+		role.PermissionTargets = pts
+		if err = role.save(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+		return warning, nil
+	}
 
 	ac, err := backend.getClient(ctx, req.Storage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
 	}
 
-	// Create/update a group
-	backend.Logger().Debug("creating/updating a group", "name", role.Name, "role_id", role.RoleID)
-	if err := ac.CreateOrReplaceGroup(role); err != nil {
-		return nil, fmt.Errorf("failed to create an artifactory group - %s", err.Error())
+	// Create/update a group, or the service user
+	backend.Logger().Debug("creating/updating a group", "name", role.Name, "role_id", role.RoleID, "mode", role.mode())
+	if err := createRolePrincipal(ctx, ac, role); err != nil {
+		return nil, err
 	}
 
 	if len(oldPts) > len(pts) {
@@ -112,15 +293,16 @@ func (backend *ArtifactoryBackend) saveRoleWithNewPermissionTargets(ctx context.
 			backend.Logger().Warn(
 				"unable to clean up unused old permission targets for role.",
 				"role_name", role.Name, "errors", cleanupErr)
+			abandoned = cleanupErr
 			return []string{cleanupErr.Error()}, nil
 		}
 	}
 
 	// Create/Update permission targets
 	for idx, pt := range pts {
-		ptName := permissionTargetName(role.Name, idx)
+		ptName := rolePermissionTargetName(role, idx)
 		backend.Logger().Debug("creating/updating a permission target", "name", ptName)
-		if err := ac.CreateOrUpdatePermissionTarget(role, &pt, ptName); err != nil {
+		if err := ac.CreateOrUpdatePermissionTarget(ctx, role, &pt, ptName); err != nil {
 			return nil, fmt.Errorf("Failed to create/update a permission target - %s", err.Error())
 		}
 	}
@@ -131,7 +313,69 @@ func (backend *ArtifactoryBackend) saveRoleWithNewPermissionTargets(ctx context.
 		return nil, err
 	}
 
-	return nil, nil
+	return warning, nil
+}
+
+// updateRoleGroup updates the Artifactory group of the role, e.g. when its description changed
+func (backend *ArtifactoryBackend) updateRoleGroup(ctx context.Context, req *logical.Request, role *RoleStorageEntry) (err error) {
+	// service users have nothing to update
+	if role.usesServiceUser() {
+		return nil
+	}
+	defer func() { backend.roleSynced(ctx, req.Storage, role.Name, err) }()
+
+	ac, err := backend.getClient(ctx, req.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+
+	backend.Logger().Debug("updating a group", "name", role.Name, "role_id", role.RoleID)
+	if err := ac.CreateOrReplaceGroup(ctx, role); err != nil {
+		return fmt.Errorf("failed to update an artifactory group - %s", err.Error())
+	}
+	return nil
+}
+
+// createRolePrincipal creates what the permission targets of the role are granted to,
+// its group or its service user
+func createRolePrincipal(ctx context.Context, ac Client, role *RoleStorageEntry) error {
+	if role.usesServiceUser() {
+		if err := ac.CreateOrReplaceServiceUser(ctx, role); err != nil {
+			return fmt.Errorf("failed to create an artifactory service user - %s", err.Error())
+		}
+		return nil
+	}
+	if err := ac.CreateOrReplaceGroup(ctx, role); err != nil {
+		return fmt.Errorf("failed to create an artifactory group - %s", err.Error())
+	}
+	return nil
+}
+
+// createRoleObjects creates the group or service user of the role, and its permission targets
+func createRoleObjects(ctx context.Context, ac Client, role *RoleStorageEntry) error {
+	if err := createRolePrincipal(ctx, ac, role); err != nil {
+		return err
+	}
+	for idx, pt := range role.PermissionTargets {
+		if err := ac.CreateOrUpdatePermissionTarget(ctx, role, &pt, rolePermissionTargetName(role, idx)); err != nil {
+			return fmt.Errorf("Failed to create/update a permission target - %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// removeRoleFromProject removes the group of the role from a project it no longer belongs to
+func (backend *ArtifactoryBackend) removeRoleFromProject(ctx context.Context, req *logical.Request, role *RoleStorageEntry, projectKey string) error {
+	ac, err := backend.getClient(ctx, req.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+
+	backend.Logger().Debug("removing a group from a project", "name", role.Name, "project_key", projectKey)
+	if err := ac.RemoveGroupFromProject(ctx, role, projectKey); err != nil {
+		return fmt.Errorf("failed to remove the group of role %s from project %s - %s", role.Name, projectKey, err.Error())
+	}
+	return nil
 }
 
 // deleteRoleEntry will remove the role with specified name from storage
@@ -150,7 +394,7 @@ func getRoleEntry(ctx context.Context, storage logical.Storage, roleName string)
 		return nil, err
 	} else if entry == nil {
 		return nil, nil
-	} else if err := entry.DecodeJSON(&result); err != nil {
+	} else if _, _, err := decodeUpgradedJSON(entry, upgradeRoleRecord, &result); err != nil {
 		return nil, err
 	}
 
@@ -178,17 +422,22 @@ func (backend *ArtifactoryBackend) tryDeleteRoleResources(ctx context.Context, r
 
 	var merr *multierror.Error
 
-	if deleteGroup {
-		if err = ac.DeleteGroup(role); err != nil {
+	if deleteGroup && role.usesServiceUser() {
+		if err = ac.DeleteServiceUser(ctx, role); err != nil {
+			backend.Logger().Info("Deleting service user from artifactory", "name", serviceUserName(role), "role", role.Name)
+			merr = multierror.Append(merr, fmt.Errorf("failed to delete a service user for role %s - %s", role.Name, err.Error()))
+		}
+	} else if deleteGroup {
+		if err = ac.DeleteGroup(ctx, role); err != nil {
 			backend.Logger().Info("Deleting group from artifactory", "name", groupName(role), "role", role.Name)
 			merr = multierror.Append(merr, fmt.Errorf("failed to delete a group for role %s - %s", role.Name, err.Error()))
 		}
 	}
 
 	for idx := range pts {
-		ptName := permissionTargetName(role.Name, idx+offset)
+		ptName := rolePermissionTargetName(role, idx+offset)
 		backend.Logger().Info("Deleting permission target from artifactory", "name", ptName, "role_name", role.Name)
-		if err := ac.DeletePermissionTarget(ptName); err != nil {
+		if err := ac.DeletePermissionTarget(ctx, ptName); err != nil {
 			merr = multierror.Append(merr, fmt.Errorf("failed to delete a permission target %s for role %s - %s", ptName, role.Name, err.Error()))
 		}
 	}
@@ -0,0 +1,93 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const roleSyncPrefix = "role-sync"
+
+// RoleSyncStatus is the outcome of the last sync of a role with Artifactory.
+// It is stored apart from the role as a failed sync doesn't save the role.
+type RoleSyncStatus struct {
+	Time    time.Time `json:"time" structs:"time" mapstructure:"time"`
+	Success bool      `json:"success" structs:"success" mapstructure:"success"`
+	Error   string    `json:"error,omitempty" structs:"error" mapstructure:"error"`
+}
+
+func (status RoleSyncStatus) details() map[string]interface{} {
+	details := map[string]interface{}{
+		"time":    status.Time.Format(time.RFC3339),
+		"success": status.Success,
+	}
+	if status.Error != "" {
+		details["error"] = status.Error
+	}
+	return details
+}
+
+func roleSyncKey(roleName string) string {
+	return fmt.Sprintf("%s/%s", roleSyncPrefix, roleName)
+}
+
+func getRoleSyncStatus(ctx context.Context, storage logical.Storage, roleName string) (*RoleSyncStatus, error) {
+	var result RoleSyncStatus
+	if entry, err := storage.Get(ctx, roleSyncKey(roleName)); err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, nil
+	} else if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// roleSynced records the outcome of a role sync as a metric and as the role's last sync status.
+// Failing to store the status doesn't fail the sync.
+func (backend *ArtifactoryBackend) roleSynced(ctx context.Context, storage logical.Storage, roleName string, err error) {
+	emitRoleSync(roleName, err)
+	backend.storeRoleSyncStatus(ctx, storage, roleName, err)
+}
+
+// storeRoleSyncStatus stores the last sync status of a stored role. Roles which aren't stored,
+// e.g. when their first sync failed, get no status.
+func (backend *ArtifactoryBackend) storeRoleSyncStatus(ctx context.Context, storage logical.Storage, roleName string, err error) {
+	role, getErr := storage.Get(ctx, fmt.Sprintf("%s/%s", rolesPrefix, roleName))
+	if getErr != nil {
+		backend.Logger().Warn("unable to read the role of the sync status", "role_name", roleName, "error", getErr)
+		return
+	}
+	if role == nil {
+		return
+	}
+
+	status := RoleSyncStatus{Time: time.Now().UTC(), Success: err == nil}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	entry, putErr := logical.StorageEntryJSON(roleSyncKey(roleName), status)
+	if putErr == nil {
+		putErr = storage.Put(ctx, entry)
+	}
+	if putErr != nil {
+		backend.Logger().Warn("unable to store the role sync status", "role_name", roleName, "error", putErr)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleTemplatesPrefix = "role-templates"
+
+	// value used to validate a template without parameters
+	templateValidationValue = "placeholder"
+)
+
+// template parameters look like {{team}}. Dotted names such as identity templates are left alone.
+var templateParamRegex = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+type RoleTemplateEntry struct {
+	// The provided name for the template
+	Name string `json:"name" structs:"name" mapstructure:"name"`
+
+	// The permission targets JSON with {{param}} placeholders
+	PermissionTargets string `json:"permission_targets" structs:"permission_targets" mapstructure:"permission_targets"`
+}
+
+// params returns the sorted, unique parameter names of the template
+func (tmpl RoleTemplateEntry) params() []string {
+	seen := map[string]bool{}
+	params := []string{}
+	for _, m := range templateParamRegex.FindAllStringSubmatch(tmpl.PermissionTargets, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			params = append(params, m[1])
+		}
+	}
+	sort.Strings(params)
+	return params
+}
+
+// render substitutes the parameters into the permission targets of the template.
+// Every template parameter must be given and no other parameter is accepted.
+func (tmpl RoleTemplateEntry) render(params map[string]string) (string, error) {
+	expected := tmpl.params()
+
+	var missing, unknown []string
+	known := map[string]bool{}
+	for _, p := range expected {
+		known[p] = true
+		if _, ok := params[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	for p := range params {
+		if !known[p] {
+			unknown = append(unknown, p)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing parameters for template '%s': %s", tmpl.Name, strings.Join(missing, ", "))
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown parameters for template '%s': %s", tmpl.Name, strings.Join(unknown, ", "))
+	}
+
+	rendered := templateParamRegex.ReplaceAllStringFunc(tmpl.PermissionTargets, func(placeholder string) string {
+		name := templateParamRegex.FindStringSubmatch(placeholder)[1]
+		// placeholders sit inside JSON strings, so the value is escaped as one.
+		// marshaling a string can't fail
+		escaped, _ := json.Marshal(params[name])
+		return string(escaped[1 : len(escaped)-1])
+	})
+	return rendered, nil
+}
+
+// validate checks the template renders into valid permission targets
+func (tmpl RoleTemplateEntry) validate() error {
+	params := map[string]string{}
+	for _, p := range tmpl.params() {
+		params[p] = templateValidationValue
+	}
+
+	rendered, err := tmpl.render(params)
+	if err != nil {
+		return err
+	}
+	_, err = parsePermissionTargets(rendered)
+	return err
+}
+
+// save saves a role template to storage
+func (tmpl RoleTemplateEntry) save(ctx context.Context, storage logical.Storage) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", roleTemplatesPrefix, tmpl.Name), tmpl)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// getRoleTemplateEntry fetches a role template from the storage
+func getRoleTemplateEntry(ctx context.Context, storage logical.Storage, name string) (*RoleTemplateEntry, error) {
+	var result RoleTemplateEntry
+	if entry, err := storage.Get(ctx, fmt.Sprintf("%s/%s", roleTemplatesPrefix, name)); err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, nil
+	} else if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// rolesUsingTemplate returns the names of the roles rendered from the template
+func (backend *ArtifactoryBackend) rolesUsingTemplate(ctx context.Context, storage logical.Storage, name string) ([]string, error) {
+	roleNames, err := backend.listRoleEntries(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	for _, roleName := range roleNames {
+		role, err := getRoleEntry(ctx, storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.Template == name {
+			roles = append(roles, roleName)
+		}
+	}
+	return roles, nil
+}
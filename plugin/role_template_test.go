@@ -0,0 +1,87 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleTemplateRender(t *testing.T) {
+	t.Parallel()
+
+	tmpl := RoleTemplateEntry{
+		Name:              "team-publish",
+		PermissionTargets: `[{"repo": {"include_patterns": ["/{{team}}/**", "/{{ team }}-{{env}}/**", "/{{identity.entity.name}}/**"], "repositories": ["libs-local"], "operations": ["write"]}}]`,
+	}
+	assert.Equal(t, []string{"env", "team"}, tmpl.params())
+
+	tests := []struct {
+		name     string
+		params   map[string]string
+		expected string
+		err      string
+	}{
+		{
+			name:     "all_params",
+			params:   map[string]string{"team": "team-x", "env": "prod"},
+			expected: `[{"repo": {"include_patterns": ["/team-x/**", "/team-x-prod/**", "/{{identity.entity.name}}/**"], "repositories": ["libs-local"], "operations": ["write"]}}]`,
+		},
+		{
+			name:     "escaped_value",
+			params:   map[string]string{"team": `a"b`, "env": "prod"},
+			expected: `[{"repo": {"include_patterns": ["/a\"b/**", "/a\"b-prod/**", "/{{identity.entity.name}}/**"], "repositories": ["libs-local"], "operations": ["write"]}}]`,
+		},
+		{
+			name:   "missing_param",
+			params: map[string]string{"team": "team-x"},
+			err:    "missing parameters for template 'team-publish': env",
+		},
+		{
+			name:   "unknown_param",
+			params: map[string]string{"team": "team-x", "env": "prod", "owner": "me"},
+			err:    "unknown parameters for template 'team-publish': owner",
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			rendered, err := tmpl.render(test.params)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, rendered)
+			_, err = parsePermissionTargets(rendered)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRoleTemplateValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := RoleTemplateEntry{PermissionTargets: `[{"repo": {"include_patterns": ["/{{team}}/**"], "repositories": ["libs-local"], "operations": ["read"]}}]`}
+	assert.NoError(t, valid.validate())
+
+	invalid := RoleTemplateEntry{PermissionTargets: `[{"repo": {"include_patterns": ["/{{team}}/**"], "operations": ["read"]}}]`}
+	assert.ErrorContains(t, invalid.validate(), "'repo.repositories' field must be supplied")
+}
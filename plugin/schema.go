@@ -0,0 +1,242 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// current schema versions of the stored records. Records without a version predate versioning.
+const (
+	configSchemaVersion = 2
+	roleSchemaVersion   = 1
+)
+
+const schemaVersionKey = "schema_version"
+
+// schemaMigration upgrades a stored record from the previous schema version to version
+type schemaMigration struct {
+	version     int
+	description string
+	migrate     func(record map[string]interface{})
+}
+
+var configMigrations = []schemaMigration{
+	{
+		version:     1,
+		description: "add the schema version",
+	},
+	{
+		version:     2,
+		description: "move the credentials to " + configCredentialsKey,
+		migrate: func(record map[string]interface{}) {
+			// readConfig picks them up from here, the config is stored without them
+			credentials := map[string]interface{}{}
+			for _, key := range []string{"bearer_token", "api_key", "password"} {
+				if value, ok := record[key]; ok {
+					credentials[key] = value
+					delete(record, key)
+				}
+			}
+			record["credentials"] = credentials
+		},
+	},
+}
+
+// role migrations also apply to the resolved roles of identity role entries
+var roleMigrations = []schemaMigration{
+	{
+		version:     1,
+		description: "store the permission targets as raw_permission_targets and permission_targets",
+		migrate: func(record map[string]interface{}) {
+			renameRecordKey(record, "RawPermissionTargets", "raw_permission_targets")
+			renameRecordKey(record, "PermissionTargets", "permission_targets")
+		},
+	},
+}
+
+func renameRecordKey(record map[string]interface{}, from, to string) {
+	if value, ok := record[from]; ok {
+		record[to] = value
+		delete(record, from)
+	}
+}
+
+// recordSchemaVersion returns the schema version of a record decoded from JSON, 0 when it has none
+func recordSchemaVersion(record map[string]interface{}) int {
+	switch v := record[schemaVersionKey].(type) {
+	case json.Number:
+		version, _ := strconv.Atoi(v.String())
+		return version
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// upgradeRecord applies the migrations newer than the schema version of the record and
+// describes what they changed. Records of a newer schema are left alone.
+func upgradeRecord(record map[string]interface{}, migrations []schemaMigration) []string {
+	var changes []string
+	for _, m := range migrations {
+		if m.version <= recordSchemaVersion(record) {
+			continue
+		}
+		if m.migrate != nil {
+			m.migrate(record)
+		}
+		record[schemaVersionKey] = m.version
+		changes = append(changes, fmt.Sprintf("v%d: %s", m.version, m.description))
+	}
+	return changes
+}
+
+func upgradeConfigRecord(record map[string]interface{}) []string {
+	return upgradeRecord(record, configMigrations)
+}
+
+func upgradeRoleRecord(record map[string]interface{}) []string {
+	return upgradeRecord(record, roleMigrations)
+}
+
+func upgradeIdentityRoleRecord(record map[string]interface{}) []string {
+	role, ok := record["role"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return upgradeRoleRecord(role)
+}
+
+// decodeUpgradedJSON decodes a stored record into out, upgrading it first when it is from an older
+// schema. It returns the upgraded record as JSON, or nil when it was up to date.
+func decodeUpgradedJSON(entry *logical.StorageEntry, upgrade func(map[string]interface{}) []string, out interface{}) ([]byte, []string, error) {
+	var record map[string]interface{}
+	if err := entry.DecodeJSON(&record); err != nil {
+		return nil, nil, err
+	}
+
+	changes := upgrade(record)
+	if len(changes) == 0 {
+		return nil, nil, entry.DecodeJSON(out)
+	}
+
+	upgraded, err := json.Marshal(record)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := jsonutil.DecodeJSON(upgraded, out); err != nil {
+		return nil, nil, err
+	}
+	return upgraded, changes, nil
+}
+
+// initialize upgrades the records stored by older releases in place
+func (b *ArtifactoryBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	return b.migrateStorage(ctx, req.Storage)
+}
+
+// migrateStorage upgrades the config, role and identity role records to the current schema.
+// Reads upgrade older records in memory as well, so records which fail to migrate keep working.
+func (b *ArtifactoryBackend) migrateStorage(ctx context.Context, storage logical.Storage) error {
+	var merr *multierror.Error
+	if err := b.migrateConfig(ctx, storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+
+	roles, err := storage.List(ctx, rolesPrefix+"/")
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := b.migrateEntry(ctx, storage, fmt.Sprintf("%s/%s", rolesPrefix, role), upgradeRoleRecord); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	baseRoles, err := storage.List(ctx, identityRolesPrefix+"/")
+	if err != nil {
+		return err
+	}
+	for _, baseRole := range baseRoles {
+		prefix := fmt.Sprintf("%s/%s", identityRolesPrefix, baseRole)
+		keys, err := storage.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := b.migrateEntry(ctx, storage, prefix+key, upgradeIdentityRoleRecord); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+	}
+
+	return merr.ErrorOrNil()
+}
+
+// migrateConfig stores the upgraded config with putConfig, which keeps the credentials apart
+func (b *ArtifactoryBackend) migrateConfig(ctx context.Context, storage logical.Storage) error {
+	cfg, changes, err := readConfig(ctx, storage)
+	if err != nil {
+		return fmt.Errorf("unable to decode %s - %s", configPrefix, err.Error())
+	}
+	if cfg == nil || len(changes) == 0 {
+		return nil
+	}
+
+	if err := putConfig(ctx, storage, cfg); err != nil {
+		if errors.Is(err, logical.ErrReadOnly) {
+			return nil
+		}
+		return fmt.Errorf("unable to store the migrated %s - %s", configPrefix, err.Error())
+	}
+	b.Logger().Info("migrated stored record", "key", configPrefix, "changes", changes)
+	return nil
+}
+
+func (b *ArtifactoryBackend) migrateEntry(ctx context.Context, storage logical.Storage, key string, upgrade func(map[string]interface{}) []string) error {
+	entry, err := storage.Get(ctx, key)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	var record map[string]interface{}
+	upgraded, changes, err := decodeUpgradedJSON(entry, upgrade, &record)
+	if err != nil {
+		return fmt.Errorf("unable to decode %s - %s", key, err.Error())
+	}
+	if upgraded == nil {
+		return nil
+	}
+
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: upgraded}); err != nil {
+		// performance standbys and secondaries can't write, their records are upgraded on read
+		if errors.Is(err, logical.ErrReadOnly) {
+			return nil
+		}
+		return fmt.Errorf("unable to store the migrated %s - %s", key, err.Error())
+	}
+	b.Logger().Info("migrated stored record", "key", key, "changes", changes)
+	return nil
+}
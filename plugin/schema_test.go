@@ -0,0 +1,202 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// schema fixtures are records as stored by older releases
+var schemaFixtures = map[string]string{
+	configPrefix:                           "config_v0.json",
+	rolesPrefix + "/ci":                    "role_v0.json",
+	rolesPrefix + "/release":               "role_v0_metadata.json",
+	identityRoleKey("dev", "1a2b3c4d5e6f"): "identity_role_v0.json",
+}
+
+func putSchemaFixtures(t *testing.T, storage logical.Storage) {
+	t.Helper()
+	for key, file := range schemaFixtures {
+		raw, err := os.ReadFile(filepath.Join("testdata", "schema", file))
+		require.NoError(t, err)
+		require.NoError(t, storage.Put(context.Background(), &logical.StorageEntry{Key: key, Value: raw}))
+	}
+}
+
+func storedRecord(t *testing.T, storage logical.Storage, key string) map[string]interface{} {
+	t.Helper()
+	entry, err := storage.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(entry.Value, &record))
+	return record
+}
+
+func TestStorageMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	b, storage := getTestBackend(t, true)
+	putSchemaFixtures(t, storage)
+
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+	for _, key := range []string{rolesPrefix + "/ci", rolesPrefix + "/release"} {
+		record := storedRecord(t, storage, key)
+		assert.EqualValues(t, roleSchemaVersion, record["schema_version"], key)
+		assert.Contains(t, record, "raw_permission_targets", key)
+		assert.Contains(t, record, "permission_targets", key)
+		assert.NotContains(t, record, "RawPermissionTargets", key)
+		assert.NotContains(t, record, "PermissionTargets", key)
+	}
+	identity := storedRecord(t, storage, identityRoleKey("dev", "1a2b3c4d5e6f"))
+	assert.EqualValues(t, roleSchemaVersion, identity["role"].(map[string]interface{})["schema_version"])
+	config := storedRecord(t, storage, configPrefix)
+	assert.EqualValues(t, configSchemaVersion, config["schema_version"])
+	assert.NotContains(t, config, "bearer_token")
+	assert.NotContains(t, config, "credentials")
+	creds, err := getConfigCredentials(ctx, storage)
+	require.NoError(t, err)
+	require.NotNil(t, creds)
+	assert.Equal(t, "mybearertoken", creds.BearerToken)
+
+	// the migrated records decode with the values of the fixtures
+	role, err := getRoleEntry(ctx, storage, "ci")
+	require.NoError(t, err)
+	assert.Equal(t, "9ace47f6-a205-11eb-8b68-acde48001122", role.RoleID)
+	assert.Equal(t, 600*time.Second, role.TokenTTL)
+	assert.Equal(t, `[{"repo":{"include_patterns":["/mytest/**"],"repositories":["libs-local"],"operations":["read","write"]}}]`, role.RawPermissionTargets)
+	require.Len(t, role.PermissionTargets, 1)
+	assert.Equal(t, []string{"libs-local"}, role.PermissionTargets[0].Repo.Repositories)
+
+	role, err = getRoleEntry(ctx, storage, "release")
+	require.NoError(t, err)
+	assert.Equal(t, "release builds", role.Description)
+	assert.Equal(t, map[string]string{"team": "platform"}, role.Labels)
+	require.Len(t, role.PermissionTargets, 1)
+	assert.Equal(t, []string{"read", "annotate"}, role.PermissionTargets[0].Build.Operations)
+
+	entry, err := getIdentityRoleEntry(ctx, storage, identityRoleKey("dev", "1a2b3c4d5e6f"))
+	require.NoError(t, err)
+	assert.Equal(t, "dev", entry.BaseRole)
+	require.Len(t, entry.Role.PermissionTargets, 1)
+	assert.Equal(t, []string{"dev-alice"}, entry.Role.PermissionTargets[0].Repo.Repositories)
+
+	cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, storage)
+	require.NoError(t, err)
+	assert.Equal(t, "mybearertoken", cfg.BearerToken)
+	assert.Equal(t, 30*time.Second, cfg.ClientTimeout)
+
+	// migrating again changes nothing
+	before := map[string][]byte{}
+	for key := range schemaFixtures {
+		e, err := storage.Get(ctx, key)
+		require.NoError(t, err)
+		before[key] = e.Value
+	}
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	for key := range schemaFixtures {
+		e, err := storage.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, string(before[key]), string(e.Value), key)
+	}
+}
+
+func TestStorageMigrationOnRead(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// records not migrated yet, e.g. on read only nodes, are upgraded when read
+	_, storage := getTestBackend(t, true)
+	putSchemaFixtures(t, storage)
+
+	cfg, changes, err := readConfig(ctx, storage)
+	require.NoError(t, err)
+	assert.Len(t, changes, configSchemaVersion)
+	assert.Equal(t, "mybearertoken", cfg.BearerToken)
+	assert.Contains(t, storedRecord(t, storage, configPrefix), "bearer_token", "reads must not write")
+
+	role, err := getRoleEntry(ctx, storage, "ci")
+	require.NoError(t, err)
+	require.Len(t, role.PermissionTargets, 1)
+	assert.NotEmpty(t, role.RawPermissionTargets)
+	assert.Equal(t, roleSchemaVersion, role.SchemaVersion)
+	assert.Contains(t, storedRecord(t, storage, rolesPrefix+"/ci"), "RawPermissionTargets", "reads must not write")
+
+	// a saved role is stored with the current schema
+	require.NoError(t, role.save(ctx, storage))
+	record := storedRecord(t, storage, rolesPrefix+"/ci")
+	assert.EqualValues(t, roleSchemaVersion, record["schema_version"])
+	assert.NotContains(t, record, "RawPermissionTargets")
+}
+
+func TestUpgradeRecord(t *testing.T) {
+	t.Parallel()
+
+	migrations := []schemaMigration{
+		{version: 1, description: "rename a", migrate: func(r map[string]interface{}) { renameRecordKey(r, "a", "b") }},
+		{version: 2, description: "rename b", migrate: func(r map[string]interface{}) { renameRecordKey(r, "b", "c") }},
+	}
+
+	tests := []struct {
+		name     string
+		record   map[string]interface{}
+		expected map[string]interface{}
+		changes  []string
+	}{
+		{
+			name:     "unversioned",
+			record:   map[string]interface{}{"a": "x"},
+			expected: map[string]interface{}{"c": "x", "schema_version": 2},
+			changes:  []string{"v1: rename a", "v2: rename b"},
+		},
+		{
+			name:     "partly_migrated",
+			record:   map[string]interface{}{"b": "x", "schema_version": json.Number("1")},
+			expected: map[string]interface{}{"c": "x", "schema_version": 2},
+			changes:  []string{"v2: rename b"},
+		},
+		{
+			name:     "current",
+			record:   map[string]interface{}{"c": "x", "schema_version": float64(2)},
+			expected: map[string]interface{}{"c": "x", "schema_version": float64(2)},
+		},
+		{
+			name:     "newer",
+			record:   map[string]interface{}{"d": "x", "schema_version": json.Number("3")},
+			expected: map[string]interface{}{"d": "x", "schema_version": json.Number("3")},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			changes := upgradeRecord(test.record, migrations)
+			assert.Equal(t, test.changes, changes)
+			assert.Equal(t, test.expected, test.record)
+		})
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	callCreateGroup = "CreateOrReplaceGroup"
+	callDeleteGroup = "DeleteGroup"
+	callUpdatePt    = "CreateOrUpdatePermissionTarget"
+	callDeletePt    = "DeletePermissionTarget"
+	callCreateToken = "CreateToken"
+)
+
+// recordingClient keeps the groups, permission targets and tokens it was asked to create. Its
+// calls can be scripted to fail, all of them, by the nth call of a method or by the role of the call.
+type recordingClient struct {
+	mockArtifactoryClient
+	mu           sync.Mutex
+	groups       map[string]bool
+	descriptions map[string]string
+	pts          map[string]PermissionTarget
+	tokens       int
+
+	err      error
+	delay    time.Duration
+	calls    map[string]int
+	failOn   map[string]int
+	rejected map[string]string
+}
+
+func newRecordingClient() *recordingClient {
+	return &recordingClient{
+		groups:       map[string]bool{},
+		descriptions: map[string]string{},
+		pts:          map[string]PermissionTarget{},
+		calls:        map[string]int{},
+		failOn:       map[string]int{},
+		rejected:     map[string]string{},
+	}
+}
+
+// failAll makes every call and ping fail with err, until it is called with nil
+func (ac *recordingClient) failAll(err error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.err = err
+}
+
+// slowDown delays every call, widening the windows for concurrent requests
+func (ac *recordingClient) slowDown(delay time.Duration) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.delay = delay
+}
+
+// failNth makes the nth call of method fail, counting from the next call
+func (ac *recordingClient) failNth(method string, nth int) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.failOn[method] = ac.calls[method] + nth
+}
+
+// rejectRole makes every call of method for the role fail
+func (ac *recordingClient) rejectRole(method string, roleName string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.rejected[method] = roleName
+}
+
+// call counts the call of method and returns its scripted failure, if any. ac.mu must be held.
+func (ac *recordingClient) call(method string, role *RoleStorageEntry) error {
+	ac.calls[method]++
+	if ac.delay > 0 {
+		ac.mu.Unlock()
+		time.Sleep(ac.delay)
+		ac.mu.Lock()
+	}
+	if ac.err != nil {
+		return ac.err
+	}
+	if ac.failOn[method] == ac.calls[method] {
+		return fmt.Errorf("Server response: 500 Internal Server Error - scripted failure of %s call %d", method, ac.calls[method])
+	}
+	if roleName, ok := ac.rejected[method]; ok && role != nil && role.Name == roleName {
+		return fmt.Errorf("Server response: 400 Bad Request - scripted rejection of %s for role %s", method, roleName)
+	}
+	return nil
+}
+
+func (ac *recordingClient) CreateOrReplaceGroup(ctx context.Context, role *RoleStorageEntry) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if err := ac.call(callCreateGroup, role); err != nil {
+		return err
+	}
+	ac.groups[groupName(role)] = true
+	ac.descriptions[groupName(role)] = groupDescription(role)
+	return nil
+}
+
+func (ac *recordingClient) DeleteGroup(ctx context.Context, role *RoleStorageEntry) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if err := ac.call(callDeleteGroup, role); err != nil {
+		return err
+	}
+	delete(ac.groups, groupName(role))
+	return nil
+}
+
+func (ac *recordingClient) CreateOrUpdatePermissionTarget(ctx context.Context, role *RoleStorageEntry, pt *PermissionTarget, ptName string) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if err := ac.call(callUpdatePt, role); err != nil {
+		return err
+	}
+	ac.pts[ptName] = *pt
+	return nil
+}
+
+func (ac *recordingClient) DeletePermissionTarget(ctx context.Context, ptName string) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if err := ac.call(callDeletePt, nil); err != nil {
+		return err
+	}
+	delete(ac.pts, ptName)
+	return nil
+}
+
+func (ac *recordingClient) CreateToken(ctx context.Context, tokenReq TokenCreateEntry, role *RoleStorageEntry) (Token, error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if err := ac.call(callCreateToken, role); err != nil {
+		return Token{}, err
+	}
+	ac.tokens++
+	return ac.mockArtifactoryClient.CreateToken(ctx, tokenReq, role)
+}
+
+func (ac *recordingClient) Ping(ctx context.Context) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.err
+}
+
+// callCount returns the number of calls of method, including the failed ones
+func (ac *recordingClient) callCount(method string) int {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.calls[method]
+}
+
+func (ac *recordingClient) counts() (int, int) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return len(ac.groups), len(ac.pts)
+}
+
+// state returns the groups and the permission targets with their repositories
+func (ac *recordingClient) state() ([]string, map[string]string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	groups := []string{}
+	for g := range ac.groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	pts := map[string]string{}
+	for name, pt := range ac.pts {
+		pts[name] = strings.Join(pt.Repo.Repositories, ",")
+	}
+	return groups, pts
+}
+
+// failingStorage fails the writes of keys with the prefix, with err or a scripted failure
+type failingStorage struct {
+	logical.Storage
+	prefix string
+	err    error
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if s.prefix != "" && strings.HasPrefix(entry.Key, s.prefix) {
+		if s.err != nil {
+			return s.err
+		}
+		return errors.New("scripted storage failure")
+	}
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestRoleSyncFailures(t *testing.T) {
+	t.Parallel()
+
+	const (
+		ptA = `{"repo": {"repositories": ["repo-a"], "operations": ["read"]}}`
+		ptB = `{"repo": {"repositories": ["repo-b"], "operations": ["read"]}}`
+		ptC = `{"repo": {"repositories": ["repo-c"], "operations": ["read"]}}`
+	)
+	group := groupName(&RoleStorageEntry{RoleID: roleID("ci")})
+	pt0, pt1 := permissionTargetName("ci", 0), permissionTargetName("ci", 1)
+
+	tests := []struct {
+		name string
+		// the role is created with existing permission targets first, when there are any
+		existing string
+		// a role update with pts, or a role delete when empty
+		pts           string
+		failMethod    string
+		failNth       int
+		failStorage   string
+		err           string
+		warning       string
+		groups        []string
+		artifactory   map[string]string
+		storedRepos   []string
+		roleRemaining bool
+	}{
+		{
+			name:        "create_group_fails",
+			pts:         "[" + ptA + "]",
+			failMethod:  callCreateGroup,
+			failNth:     1,
+			err:         "failed to create an artifactory group",
+			groups:      []string{},
+			artifactory: map[string]string{},
+		},
+		{
+			name:        "create_second_permission_target_fails",
+			pts:         "[" + ptA + "," + ptB + "]",
+			failMethod:  callUpdatePt,
+			failNth:     2,
+			err:         "Failed to create/update a permission target",
+			groups:      []string{group},
+			artifactory: map[string]string{pt0: "repo-a"},
+		},
+		{
+			name:        "create_storage_fails",
+			pts:         "[" + ptA + "]",
+			failStorage: rolesPrefix + "/",
+			err:         "scripted storage failure",
+			groups:      []string{group},
+			artifactory: map[string]string{pt0: "repo-a"},
+		},
+		{
+			name:       "update_permission_target_fails",
+			existing:   "[" + ptA + "," + ptB + "]",
+			pts:        "[" + ptC + "]",
+			failMethod: callUpdatePt,
+			failNth:    1,
+			err:        "Failed to create/update a permission target",
+			groups:     []string{group},
+			// excessive permission targets are removed before the new ones are created
+			artifactory:   map[string]string{pt0: "repo-a"},
+			storedRepos:   []string{"repo-a", "repo-b"},
+			roleRemaining: true,
+		},
+		{
+			name:       "update_excess_permission_target_delete_fails",
+			existing:   "[" + ptA + "," + ptB + "]",
+			pts:        "[" + ptC + "]",
+			failMethod: callDeletePt,
+			failNth:    1,
+			warning:    "failed to delete a permission target " + pt1,
+			groups:     []string{group},
+			// the update stops at the failed removal, without saving the role
+			artifactory:   map[string]string{pt0: "repo-a", pt1: "repo-b"},
+			storedRepos:   []string{"repo-a", "repo-b"},
+			roleRemaining: true,
+		},
+		{
+			name:          "update_storage_fails",
+			existing:      "[" + ptA + "]",
+			pts:           "[" + ptC + "]",
+			failStorage:   rolesPrefix + "/",
+			err:           "scripted storage failure",
+			groups:        []string{group},
+			artifactory:   map[string]string{pt0: "repo-c"},
+			storedRepos:   []string{"repo-a"},
+			roleRemaining: true,
+		},
+		{
+			name:        "delete_group_fails",
+			existing:    "[" + ptA + "," + ptB + "]",
+			failMethod:  callDeleteGroup,
+			failNth:     1,
+			warning:     "failed to delete a group for role ci",
+			groups:      []string{group},
+			artifactory: map[string]string{},
+		},
+		{
+			name:        "delete_permission_target_fails",
+			existing:    "[" + ptA + "," + ptB + "]",
+			failMethod:  callDeletePt,
+			failNth:     1,
+			warning:     "failed to delete a permission target " + pt0,
+			groups:      []string{},
+			artifactory: map[string]string{pt0: "repo-a"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			req, backend, _, _ := newIdentityMockEnv(t)
+			client := newRecordingClient()
+			backend.client = client
+			storage := &failingStorage{Storage: req.Storage}
+			req.Storage = storage
+
+			if test.existing != "" {
+				mustRoleCreate(req, backend, t, "ci", map[string]interface{}{
+					"permission_targets": test.existing,
+				})
+			}
+			if test.failMethod != "" {
+				client.failNth(test.failMethod, test.failNth)
+			}
+			storage.prefix = test.failStorage
+
+			var resp *logical.Response
+			var err error
+			if test.pts != "" {
+				resp, err = testRoleUpdate(req, backend, t, "ci", map[string]interface{}{
+					"permission_targets": test.pts,
+				})
+			} else {
+				resp, err = testRoleDelete(req, backend, t, "ci")
+			}
+			require.NoError(t, err)
+
+			if test.err != "" {
+				require.True(t, resp.IsError(), "expecting error")
+				assert.Contains(t, resp.Data["error"], test.err)
+			} else {
+				require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+			}
+			if test.warning != "" {
+				require.NotNil(t, resp)
+				require.Len(t, resp.Warnings, 1)
+				assert.Contains(t, resp.Warnings[0], test.warning)
+			}
+
+			groups, pts := client.state()
+			assert.Equal(t, test.groups, groups, "artifactory groups")
+			assert.Equal(t, test.artifactory, pts, "artifactory permission targets")
+
+			storage.prefix = ""
+			role, err := getRoleEntry(ctx, req.Storage, "ci")
+			require.NoError(t, err)
+			status, err := getRoleSyncStatus(ctx, req.Storage, "ci")
+			require.NoError(t, err)
+			if !test.roleRemaining {
+				assert.Nil(t, role, "stored role")
+				assert.Nil(t, status, "sync status of a role which isn't stored")
+				return
+			}
+			require.NotNil(t, role, "stored role")
+			require.NotNil(t, status, "sync status")
+			assert.Equal(t, test.err == "" && test.warning == "", status.Success, "sync status")
+			if test.warning != "" {
+				assert.Contains(t, status.Error, test.warning, "sync status")
+			}
+			repos := []string{}
+			for _, pt := range role.PermissionTargets {
+				repos = append(repos, pt.Repo.Repositories...)
+			}
+			assert.Equal(t, test.storedRepos, repos, "stored permission targets")
+		})
+	}
+}
@@ -29,6 +29,9 @@ const (
 // TokenCreateEntry is the structure for creating a token
 type TokenCreateEntry struct {
 	TTL time.Duration `json:"ttl" structs:"ttl" mapstructure:"ttl"`
+
+	// ReferenceToken asks JFrog Access for a reference token along with the access token
+	ReferenceToken bool `json:"reference_token" structs:"reference_token" mapstructure:"reference_token"`
 }
 
 func (backend *ArtifactoryBackend) createTokenEntry(ctx context.Context, storage logical.Storage, createEntry TokenCreateEntry, roleEntry *RoleStorageEntry) (map[string]interface{}, error) {
@@ -37,14 +40,17 @@ func (backend *ArtifactoryBackend) createTokenEntry(ctx context.Context, storage
 		return nil, fmt.Errorf("failed to obtain artifactory client: %v", err)
 	}
 
-	token, err := ac.CreateToken(createEntry, roleEntry)
+	token, err := ac.CreateToken(ctx, createEntry, roleEntry)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create a token: %v", err)
+		return nil, fmt.Errorf("failed to create a token: %w", err)
 	}
 
 	tokenOutput := map[string]interface{}{
 		"access_token": token.AccessToken,
-		"username":     tokenUsername(roleEntry.Name),
+		"username":     roleTokenUsername(roleEntry),
+	}
+	if createEntry.ReferenceToken {
+		tokenOutput["reference_token"] = token.ReferenceToken
 	}
 
 	return tokenOutput, nil
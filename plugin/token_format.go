@@ -0,0 +1,280 @@
+// Copyright  2021 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	tokenFormatRaw    = "raw"
+	tokenFormatDocker = "docker"
+	tokenFormatNpm    = "npm"
+	tokenFormatPip    = "pip"
+	tokenFormatMaven  = "maven"
+	tokenFormatGradle = "gradle"
+	tokenFormatGo     = "go"
+	tokenFormatHelm   = "helm"
+)
+
+// tokenFormatter renders tool specific credentials from an issued token.
+// The returned fields are added to the token response.
+type tokenFormatter func(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error)
+
+var tokenFormatters = map[string]tokenFormatter{
+	tokenFormatRaw:    formatRaw,
+	tokenFormatDocker: formatDocker,
+	tokenFormatNpm:    formatNpm,
+	tokenFormatPip:    formatPip,
+	tokenFormatMaven:  formatMaven,
+	tokenFormatGradle: formatGradle,
+	tokenFormatGo:     formatGo,
+	tokenFormatHelm:   formatHelm,
+}
+
+func tokenFormats() []string {
+	formats := make([]string, 0, len(tokenFormatters))
+	for f := range tokenFormatters {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// checkTokenFormat reports why the credentials of role can't be rendered in format. It runs before
+// the token is created, so no token is issued which isn't returned.
+func checkTokenFormat(format string, config *ConfigStorageEntry, role *RoleStorageEntry) error {
+	switch format {
+	case tokenFormatDocker:
+		_, err := dockerRegistry(config, role)
+		return err
+	case tokenFormatNpm, tokenFormatPip, tokenFormatMaven, tokenFormatGo, tokenFormatHelm:
+		_, err := formatRepositories(role, format)
+		return err
+	}
+	return nil
+}
+
+func formatRaw(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// dockerRegistry returns the docker registry of the role, the host of the base url by default
+func dockerRegistry(config *ConfigStorageEntry, role *RoleStorageEntry) (string, error) {
+	if role.DockerRegistry != "" {
+		return role.DockerRegistry, nil
+	}
+	u, err := url.Parse(config.BaseURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("unable to determine docker registry from base url '%s'", config.BaseURL)
+	}
+	return u.Host, nil
+}
+
+// formatDocker renders a docker config.json with an auths entry for the registry
+func formatDocker(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	registry, err := dockerRegistry(config, role)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerConfig := map[string]interface{}{
+		"auths": map[string]interface{}{
+			registry: map[string]string{
+				"auth": base64.StdEncoding.EncodeToString([]byte(username + ":" + token)),
+			},
+		},
+	}
+	raw, err := json.Marshal(dockerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"registry":      registry,
+		"docker_config": string(raw),
+	}, nil
+}
+
+// formatNpm renders an .npmrc using the first repository as the default registry
+func formatNpm(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	repos, err := formatRepositories(role, tokenFormatNpm)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "registry=%s\n", repositoryURL(config, "api/npm/"+repos[0]+"/"))
+	for _, repo := range repos {
+		// npm keys credentials by the registry url without its scheme
+		registry := strings.TrimPrefix(strings.TrimPrefix(repositoryURL(config, "api/npm/"+repo+"/"), "https:"), "http:")
+		fmt.Fprintf(&b, "%s:_authToken=%s\n", registry, token)
+		fmt.Fprintf(&b, "%s:always-auth=true\n", registry)
+	}
+
+	return map[string]interface{}{
+		"npmrc": b.String(),
+	}, nil
+}
+
+// formatPip renders a pip.conf using the first repository as the index
+func formatPip(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	repos, err := formatRepositories(role, tokenFormatPip)
+	if err != nil {
+		return nil, err
+	}
+
+	indexURLs := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		u, err := credentialURL(config, username, token, "api/pypi/"+repo+"/simple")
+		if err != nil {
+			return nil, err
+		}
+		indexURLs = append(indexURLs, u)
+	}
+
+	var b strings.Builder
+	b.WriteString("[global]\n")
+	fmt.Fprintf(&b, "index-url = %s\n", indexURLs[0])
+	if len(indexURLs) > 1 {
+		fmt.Fprintf(&b, "extra-index-url = %s\n", strings.Join(indexURLs[1:], "\n    "))
+	}
+
+	return map[string]interface{}{
+		"pip_conf": b.String(),
+	}, nil
+}
+
+type mavenServer struct {
+	ID       string `xml:"id"`
+	Username string `xml:"username"`
+	Password string `xml:"password"`
+}
+
+type mavenSettings struct {
+	XMLName xml.Name      `xml:"settings"`
+	Servers []mavenServer `xml:"servers>server"`
+}
+
+// formatMaven renders a settings.xml with a server block per repository
+func formatMaven(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	repos, err := formatRepositories(role, tokenFormatMaven)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := mavenSettings{}
+	for _, repo := range repos {
+		settings.Servers = append(settings.Servers, mavenServer{ID: repo, Username: username, Password: token})
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(settings); err != nil {
+		return nil, err
+	}
+	b.WriteString("\n")
+
+	return map[string]interface{}{
+		"maven_settings": b.String(),
+	}, nil
+}
+
+// formatGradle renders gradle.properties with the Artifactory context url and credentials
+func formatGradle(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "artifactory_contextUrl=%s\n", strings.TrimSuffix(config.BaseURL, "/"))
+	fmt.Fprintf(&b, "artifactory_user=%s\n", username)
+	fmt.Fprintf(&b, "artifactory_password=%s\n", token)
+
+	return map[string]interface{}{
+		"gradle_properties": b.String(),
+	}, nil
+}
+
+// formatGo renders a GOPROXY value listing every repository of the role
+func formatGo(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	repos, err := formatRepositories(role, tokenFormatGo)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		u, err := credentialURL(config, username, token, "api/go/"+repo)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, u)
+	}
+
+	return map[string]interface{}{
+		"goproxy": strings.Join(proxies, ","),
+	}, nil
+}
+
+// formatHelm renders a helm repositories.yaml with an entry per repository
+func formatHelm(config *ConfigStorageEntry, role *RoleStorageEntry, username, token string) (map[string]interface{}, error) {
+	repos, err := formatRepositories(role, tokenFormatHelm)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\nrepositories:\n")
+	for _, repo := range repos {
+		fmt.Fprintf(&b, "- name: %s\n", repo)
+		fmt.Fprintf(&b, "  url: %s\n", repositoryURL(config, "api/helm/"+repo))
+		fmt.Fprintf(&b, "  username: %q\n", username)
+		fmt.Fprintf(&b, "  password: %q\n", token)
+	}
+
+	return map[string]interface{}{
+		"helm_repositories": b.String(),
+	}, nil
+}
+
+// formatRepositories returns the repositories of a role that config can be rendered for
+func formatRepositories(role *RoleStorageEntry, format string) ([]string, error) {
+	repos := roleRepositories(role)
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("role '%s' has no named repositories to render %s config for", role.Name, format)
+	}
+	return repos, nil
+}
+
+// repositoryURL returns the url of an Artifactory api path
+func repositoryURL(config *ConfigStorageEntry, path string) string {
+	return appendTrailingSlash(config.BaseURL) + path
+}
+
+// credentialURL returns the url of an Artifactory api path with credentials embedded
+func credentialURL(config *ConfigStorageEntry, username, token, path string) (string, error) {
+	u, err := url.Parse(repositoryURL(config, path))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse base url '%s' - %w", config.BaseURL, err)
+	}
+	u.User = url.UserPassword(username, token)
+	return u.String(), nil
+}
@@ -17,7 +17,10 @@ package artifactorysecrets
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
@@ -26,18 +29,53 @@ import (
 
 const (
 	pluginPrefix         = "vault-plugin"
-	tokenUsernamePrefix  = "auto-vault-plugin"
 	tokenUsernameMaxLen  = 58
 	tokenUsernameHashLen = 8
 	roleIDHashLen        = 32
 )
 
+// label keys are kept simple so they can be used in filters
+var labelKeyRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_./-]*[A-Za-z0-9])?$`)
+
+// project keys and project role names end up in token scopes, so they can't hold scope separators
+var (
+	projectKeyRegex  = regexp.MustCompile(`^[a-z][a-z0-9-]{1,31}$`)
+	projectRoleRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
 func groupName(roleEntry *RoleStorageEntry) string {
-	return fmt.Sprintf("%s.%s", pluginPrefix, roleEntry.RoleID)
+	return roleEntry.naming().group(roleEntry.RoleID)
+}
+
+// serviceUserName is the name of the service user of a role in user mode
+func serviceUserName(roleEntry *RoleStorageEntry) string {
+	return roleEntry.naming().serviceUser(roleEntry.RoleID)
+}
+
+// roleTokenUsername is the user tokens of the role are issued for, a transient one unless in user mode
+func roleTokenUsername(roleEntry *RoleStorageEntry) string {
+	if roleEntry.usesServiceUser() {
+		return serviceUserName(roleEntry)
+	}
+	return roleEntry.naming().tokenUsername(roleEntry.Name, roleEntry.RoleID)
+}
+
+// rolePermissionTargetName is the name of a permission target of the role
+func rolePermissionTargetName(roleEntry *RoleStorageEntry, index int) string {
+	return roleEntry.naming().permissionTarget(roleEntry.Name, roleEntry.RoleID, index)
+}
+
+// groupDescription is the description of the Artifactory group of a role
+func groupDescription(roleEntry *RoleStorageEntry) string {
+	if roleEntry.Description != "" {
+		return roleEntry.Description
+	}
+	return fmt.Sprintf("vault plugin group for %s", roleEntry.Name)
 }
 
+// permissionTargetName is the name of a permission target of a role with the default naming
 func permissionTargetName(roleName string, index int) string {
-	return fmt.Sprintf("%s.pt%d.%s", pluginPrefix, index, roleName)
+	return defaultNaming.permissionTarget(roleName, roleID(roleName), index)
 }
 
 func roleID(roleName string) string {
@@ -45,15 +83,9 @@ func roleID(roleName string) string {
 	return fmt.Sprintf("%x", roleID)[:roleIDHashLen]
 }
 
+// tokenUsername is the token username of a role with the default naming
 func tokenUsername(roleName string) string {
-	fullUsername := fmt.Sprintf("%s.%s", tokenUsernamePrefix, roleName)
-	tokenUser := fullUsername
-	if len(fullUsername) > tokenUsernameMaxLen {
-		truncIndex := tokenUsernameMaxLen - tokenUsernameHashLen
-		h := fmt.Sprintf("%x", sha256.Sum256([]byte(fullUsername[truncIndex:])))
-		tokenUser = fullUsername[:truncIndex] + h[:tokenUsernameHashLen]
-	}
-	return tokenUser
+	return defaultNaming.tokenUsername(roleName, roleID(roleName))
 }
 
 // appendTrailingSlash appends trailing slash if url doesn't end with slash.
@@ -97,6 +129,66 @@ func convertPermissionTarget(fromPt *PermissionTarget, toPt *services.Permission
 	toPt.Name = ptName
 }
 
+// grantToUser moves what a converted permission target grants to the group over to the user
+func grantToUser(pt *services.PermissionTargetParams, groupName, userName string) {
+	for _, section := range []*services.PermissionTargetSection{pt.Repo, pt.Build} {
+		if section != nil && section.Actions != nil {
+			section.Actions = &services.Actions{Users: map[string][]string{userName: section.Actions.Groups[groupName]}}
+		}
+	}
+}
+
+// roleRepositories returns the sorted, unique repositories of a role's repo permission targets.
+// Artifactory pseudo repositories such as "ANY LOCAL" are left out.
+func roleRepositories(role *RoleStorageEntry) []string {
+	seen := map[string]bool{}
+	repos := []string{}
+	for _, pt := range role.PermissionTargets {
+		if pt.Repo == nil {
+			continue
+		}
+		for _, repo := range pt.Repo.Repositories {
+			if seen[repo] || isPseudoRepository(repo) {
+				continue
+			}
+			seen[repo] = true
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// roleOperations returns the sorted, unique operations granted by a role's permission targets
+func roleOperations(role *RoleStorageEntry) []string {
+	seen := map[string]bool{}
+	ops := []string{}
+	for _, pt := range role.PermissionTargets {
+		for _, p := range []*Permission{pt.Repo, pt.Build} {
+			if p == nil {
+				continue
+			}
+			for _, op := range p.Operations {
+				if !seen[op] {
+					seen[op] = true
+					ops = append(ops, op)
+				}
+			}
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// isPseudoRepository reports whether repo is one of Artifactory's "ANY" repository placeholders
+func isPseudoRepository(repo string) bool {
+	switch repo {
+	case "ANY", "ANY LOCAL", "ANY REMOTE", "ANY DISTRIBUTION":
+		return true
+	}
+	return false
+}
+
 func validateOperations(ops []string) error {
 	var err *multierror.Error
 
@@ -114,6 +206,54 @@ func validateOperations(ops []string) error {
 	return err.ErrorOrNil()
 }
 
+func validateLabels(labels map[string]string) error {
+	var err *multierror.Error
+
+	for key := range labels {
+		if !labelKeyRegex.MatchString(key) {
+			err = multierror.Append(err, fmt.Errorf("label key '%s' is not allowed", key))
+		}
+	}
+
+	return err.ErrorOrNil()
+}
+
+// validateProject checks the project key and project roles of a role, which go together
+func validateProject(projectKey string, projectRoles []string) error {
+	switch {
+	case projectKey == "" && len(projectRoles) == 0:
+		return nil
+	case projectKey == "":
+		return errors.New("project_roles need a project_key")
+	case len(projectRoles) == 0:
+		return errors.New("project_key needs project_roles")
+	case !projectKeyRegex.MatchString(projectKey):
+		return fmt.Errorf("project key '%s' is not allowed", projectKey)
+	}
+
+	var err *multierror.Error
+	for _, projectRole := range projectRoles {
+		if !projectRoleRegex.MatchString(projectRole) {
+			err = multierror.Append(err, fmt.Errorf("project role '%s' is not allowed", projectRole))
+		}
+	}
+	return err.ErrorOrNil()
+}
+
+// validateMode checks the mode of a role, project roles are granted to groups only
+func validateMode(mode, projectKey string) error {
+	switch mode {
+	case "", roleModeGroup:
+		return nil
+	case roleModeUser:
+		if projectKey != "" {
+			return errors.New("project roles are only supported in group mode")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s', expecting %s or %s", mode, roleModeGroup, roleModeUser)
+}
+
 func getStringHash(ptsRaw string) string {
 	ssum := sha256.Sum256([]byte(ptsRaw))
 	return base64.StdEncoding.EncodeToString(ssum[:])
@@ -24,8 +24,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-const maxArtifactoryNameLen = 64
-
 func TestValidatePermissionTarget(t *testing.T) {
 
 	t.Parallel()
@@ -100,6 +98,88 @@ func TestValidateOperations(t *testing.T) {
 	})
 }
 
+func TestValidateProject(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		key   string
+		roles []string
+		err   string
+	}{
+		{name: "none"},
+		{name: "valid", key: "ml-platform", roles: []string{"Developer", "release_manager"}},
+		{name: "roles_without_key", roles: []string{"Developer"}, err: "project_roles need a project_key"},
+		{name: "key_without_roles", key: "ml", err: "project_key needs project_roles"},
+		{name: "invalid_key", key: "ML", roles: []string{"Developer"}, err: "project key 'ML' is not allowed"},
+		{name: "role_with_space", key: "ml", roles: []string{"Release Manager"}, err: "project role 'Release Manager' is not allowed"},
+		{name: "role_with_separator", key: "ml", roles: []string{"dev:ops"}, err: "project role 'dev:ops' is not allowed"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateProject(test.key, test.roles)
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		mode       string
+		projectKey string
+		err        string
+	}{
+		{name: "default"},
+		{name: "group", mode: "group", projectKey: "ml"},
+		{name: "user", mode: "user"},
+		{name: "user_with_project", mode: "user", projectKey: "ml", err: "project roles are only supported in group mode"},
+		{name: "unknown", mode: "users", err: "unknown mode 'users', expecting group or user"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateMode(test.mode, test.projectKey)
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+func TestGrantToUser(t *testing.T) {
+	t.Parallel()
+
+	pt := PermissionTarget{
+		Repo:  &Permission{Repositories: []string{"libs-local"}, Operations: []string{"read"}},
+		Build: &Permission{Repositories: []string{"artifactory-build-info"}, Operations: []string{"read", "annotate"}},
+	}
+	params := services.PermissionTargetParams{}
+	convertPermissionTarget(&pt, &params, "vault-plugin.group", "vault-plugin.pt0.ci")
+	grantToUser(&params, "vault-plugin.group", "vault-plugin.user.ci")
+
+	assert.Nil(t, params.Repo.Actions.Groups)
+	assert.Equal(t, map[string][]string{"vault-plugin.user.ci": {"read"}}, params.Repo.Actions.Users)
+	assert.Nil(t, params.Build.Actions.Groups)
+	assert.Equal(t, map[string][]string{"vault-plugin.user.ci": {"read", "annotate"}}, params.Build.Actions.Users)
+	assert.Equal(t, []string{"libs-local"}, params.Repo.Repositories)
+}
+
 func TestConvertPermissionTarget(t *testing.T) {
 	t.Parallel()
 